@@ -0,0 +1,209 @@
+package connector
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/GroveJay/matrix-macOS-Messages-bridge/pkg/macos"
+	"maunium.net/go/mautrix/bridgev2"
+	"maunium.net/go/mautrix/bridgev2/database"
+	"maunium.net/go/mautrix/bridgev2/networkid"
+)
+
+var _ bridgev2.BackfillingNetworkAPI = (*MessagesClient)(nil)
+
+var latestMessageCursor = macos.MessageCursor{Date: math.MaxInt64, RowID: math.MaxInt}
+
+// FetchMessages implements bridgev2.BackfillingNetworkAPI.
+func (m *MessagesClient) FetchMessages(ctx context.Context, params bridgev2.FetchMessagesParams) (*bridgev2.FetchMessagesResponse, error) {
+	_, chatGUID, err := macos.ParseMessagesPortalID(params.Portal.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	after := macos.MessageCursor{}
+	before := latestMessageCursor
+	if params.Forward {
+		if params.AnchorMessage != nil {
+			after = m.getAnchorCursor(params.AnchorMessage)
+		}
+	} else if params.Cursor != "" {
+		if before, err = parsePaginationCursor(params.Cursor); err != nil {
+			return nil, err
+		}
+	} else if params.AnchorMessage != nil {
+		before = m.getAnchorCursor(params.AnchorMessage)
+	}
+
+	if maxAge := m.Config.Backfill.MaxAge; maxAge > 0 {
+		oldest := time.Now().Add(-maxAge).UnixNano() - macos.AppleEpochUnixNano
+		if after.Date < oldest {
+			after = macos.MessageCursor{Date: oldest}
+		}
+	}
+	count := params.Count
+	if maxBatchSize := m.Config.Backfill.MaxBatchSize; maxBatchSize > 0 && count > maxBatchSize {
+		count = maxBatchSize
+	}
+
+	messages, err := m.MacOSMessagesClient.GetChatMessagesPage(chatGUID, after, before, count)
+	if err != nil {
+		return nil, err
+	}
+	response := &bridgev2.FetchMessagesResponse{
+		Forward: params.Forward,
+		HasMore: len(messages) >= count,
+	}
+	if len(messages) == 0 {
+		return response, nil
+	}
+	response.Cursor = formatPaginationCursor(messages[0].GetCursor())
+
+	reactions, err := m.getBackfillReactions(chatGUID, messages)
+	if err != nil {
+		return nil, err
+	}
+	var editedMessages []*macos.Message
+	for _, message := range messages {
+		// Group changes are synced as chat info and unsent messages have nothing left to show
+		if message.ItemType != macos.ItemTypeMessage || message.IsFullyUnsent() {
+			continue
+		}
+		// Edited messages are backfilled as they were sent, and their edits are bridged once the batch is in
+		converted, err := m.ConvertMessage(ctx, params.Portal, m.UserLogin.Bridge.Bot, message.WithoutEdits())
+		if err != nil {
+			m.UserLogin.Log.Warn().Msgf("Failed to convert message %s for backfill: %v", message.GUID, err)
+			continue
+		}
+		response.Messages = append(response.Messages, &bridgev2.BackfillMessage{
+			ConvertedMessage: converted,
+			Sender: bridgev2.EventSender{
+				Sender:   networkid.UserID(message.Sender.LocalID),
+				IsFromMe: message.IsFromMe,
+			},
+			ID:        networkid.MessageID(message.GUID),
+			Timestamp: message.CreatedAt,
+			Reactions: reactionsToParts(reactions[message.GUID], converted.Parts),
+		})
+		if len(message.GetPartEdits()) > 0 {
+			editedMessages = append(editedMessages, message)
+		}
+	}
+	if len(editedMessages) > 0 {
+		response.CompleteCallback = m.queueBackfilledEdits(editedMessages)
+	}
+	return response, nil
+}
+
+// getAnchorCursor finds the position of an already bridged message in chat.db,
+// falling back to its timestamp for messages whose ID isn't a chat.db GUID.
+func (m *MessagesClient) getAnchorCursor(anchor *database.Message) macos.MessageCursor {
+	cursor, err := m.MacOSMessagesClient.GetMessageCursor(string(anchor.ID))
+	if err != nil {
+		m.UserLogin.Log.Debug().Msgf("Using timestamp of backfill anchor %s: %v", anchor.ID, err)
+		return macos.MessageCursor{Date: anchor.Timestamp.UnixNano() - macos.AppleEpochUnixNano}
+	}
+	return *cursor
+}
+
+// getBackfillReactions collects the current tapbacks on the messages, keyed by the GUID of the message they target.
+// Tapbacks are always newer than their target, so only ones since the oldest message need to be considered.
+func (m *MessagesClient) getBackfillReactions(chatGUID string, messages []*macos.Message) (map[string][]*bridgev2.BackfillReaction, error) {
+	tapbacks, err := m.MacOSMessagesClient.GetChatTapbacksSince(chatGUID, messages[0].Date)
+	if err != nil {
+		return nil, err
+	}
+	targets := make(map[string]struct{}, len(messages))
+	for _, message := range messages {
+		targets[message.GUID] = struct{}{}
+	}
+
+	// Each sender has at most one tapback on each part of a message, and later tapbacks replace or remove earlier ones
+	type reactionKey struct {
+		target string
+		part   networkid.PartID
+		sender string
+		isMe   bool
+	}
+	var order []reactionKey
+	current := make(map[reactionKey]*bridgev2.BackfillReaction)
+	for _, tapback := range tapbacks {
+		if tapback.Tapback == nil {
+			continue
+		}
+		if _, ok := targets[tapback.Tapback.TargetGUID]; !ok {
+			continue
+		}
+		key := reactionKey{
+			target: tapback.Tapback.TargetGUID,
+			part:   tapback.Tapback.GetTargetPartID(),
+			sender: tapback.Sender.LocalID,
+			isMe:   tapback.IsFromMe,
+		}
+		if tapback.Tapback.Remove {
+			delete(current, key)
+			continue
+		}
+		if !slices.Contains(order, key) {
+			order = append(order, key)
+		}
+		emoji := tapback.Tapback.GetEmoji()
+		current[key] = &bridgev2.BackfillReaction{
+			TargetPart: &key.part,
+			Timestamp:  tapback.CreatedAt,
+			Sender: bridgev2.EventSender{
+				Sender:   networkid.UserID(tapback.Sender.LocalID),
+				IsFromMe: tapback.IsFromMe,
+			},
+			Emoji:   emoji,
+			EmojiID: networkid.EmojiID(emoji),
+		}
+	}
+
+	reactions := make(map[string][]*bridgev2.BackfillReaction)
+	for _, key := range order {
+		if reaction, ok := current[key]; ok {
+			reactions[key.target] = append(reactions[key.target], reaction)
+		}
+	}
+	return reactions, nil
+}
+
+// reactionsToParts points tapbacks on parts that weren't bridged, like unsent ones, at the first part instead.
+func reactionsToParts(reactions []*bridgev2.BackfillReaction, parts []*bridgev2.ConvertedMessagePart) []*bridgev2.BackfillReaction {
+	for _, reaction := range reactions {
+		if reaction.TargetPart == nil {
+			continue
+		}
+		targetPart := *reaction.TargetPart
+		if !slices.ContainsFunc(parts, func(part *bridgev2.ConvertedMessagePart) bool { return part.ID == targetPart }) {
+			reaction.TargetPart = nil
+		}
+	}
+	return reactions
+}
+
+func formatPaginationCursor(cursor macos.MessageCursor) networkid.PaginationCursor {
+	return networkid.PaginationCursor(fmt.Sprintf("%d:%d", cursor.Date, cursor.RowID))
+}
+
+func parsePaginationCursor(cursor networkid.PaginationCursor) (macos.MessageCursor, error) {
+	date, rowID, found := strings.Cut(string(cursor), ":")
+	if !found {
+		return macos.MessageCursor{}, fmt.Errorf("invalid pagination cursor %q", cursor)
+	}
+	var parsed macos.MessageCursor
+	var err error
+	if parsed.Date, err = strconv.ParseInt(date, 10, 64); err != nil {
+		return parsed, fmt.Errorf("invalid date in pagination cursor %q: %w", cursor, err)
+	}
+	if parsed.RowID, err = strconv.Atoi(rowID); err != nil {
+		return parsed, fmt.Errorf("invalid ROWID in pagination cursor %q: %w", cursor, err)
+	}
+	return parsed, nil
+}
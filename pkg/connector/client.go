@@ -3,9 +3,11 @@ package connector
 import (
 	"context"
 	"fmt"
-	"os"
+	"math"
 	"path/filepath"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/GroveJay/matrix-macOS-Messages-bridge/pkg/macos"
@@ -17,6 +19,7 @@ import (
 	"maunium.net/go/mautrix/bridgev2/simplevent"
 	"maunium.net/go/mautrix/bridgev2/status"
 	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
 )
 
 type MessagesClient struct {
@@ -24,10 +27,23 @@ type MessagesClient struct {
 	MacOSMessagesClient          *macos.MacOSMessagesClient
 	MacOSContactsClient          *macos.MacOSContactsClient
 	MessagesDBWatcherStopChannel chan struct{}
-	MessagesChannel              chan *macos.Message
+	MessagesChannel              chan *ingestedMessage
 	ReadReceiptsChannel          chan *macos.ReadReceipt
 	HandleMessagesStopChannel    chan struct{}
+	OutgoingCheckStopChannel     chan struct{}
+	Config                       *Config
+	ScriptRunner                 macos.ScriptRunner
+	AttachmentSpool              *macos.AttachmentSpool
 	DryRun                       bool
+
+	pendingSends         *pendingSendTracker
+	pendingSendsLock     sync.Mutex
+	outgoingMessages     map[string]*outgoingMessage
+	outgoingMessagesLock sync.Mutex
+	ingestCursorLock     sync.Mutex
+	// The batch whose message is being handled, which the events queued meanwhile are counted towards
+	handlingBatch     *ingestBatch
+	handlingBatchLock sync.Mutex
 }
 
 var _ bridgev2.NetworkAPI = (*MessagesClient)(nil)
@@ -36,7 +52,12 @@ func (m *MessagesClient) Connect(ctx context.Context) {
 	var err error
 	meta := m.UserLogin.Metadata.(*UserLoginMetadata)
 	userID := meta.UserID
-	if m.MacOSMessagesClient, err = macos.GetMessagesClient(userID, &m.UserLogin.Log); err != nil {
+	paths := macos.DataPaths{
+		Home:               m.Config.Paths.Home,
+		ChatDB:             m.Config.Paths.ChatDB,
+		AddressBookSources: m.Config.Paths.AddressBookSources,
+	}
+	if m.MacOSMessagesClient, err = macos.GetMessagesClient(userID, &m.UserLogin.Log, paths, m.ScriptRunner); err != nil {
 		m.UserLogin.BridgeState.Send(status.BridgeState{
 			StateEvent: status.StateBadCredentials,
 			Error:      "macos-messages-connect-messages-client",
@@ -45,7 +66,7 @@ func (m *MessagesClient) Connect(ctx context.Context) {
 		})
 		return
 	}
-	if m.MacOSContactsClient, err = macos.GetContactsClient(userID); err != nil {
+	if m.MacOSContactsClient, err = macos.GetContactsClient(userID, paths, m.Config.DefaultRegion, m.ScriptRunner); err != nil {
 		m.UserLogin.BridgeState.Send(status.BridgeState{
 			StateEvent: status.StateBadCredentials,
 			Error:      "macos-messages-connect-contacts-client",
@@ -75,7 +96,8 @@ func (m *MessagesClient) Connect(ctx context.Context) {
 
 	m.MessagesDBWatcherStopChannel = make(chan struct{}, 1)
 	m.HandleMessagesStopChannel = make(chan struct{}, 1)
-	m.MessagesChannel = make(chan *macos.Message)
+	m.OutgoingCheckStopChannel = make(chan struct{}, 1)
+	m.MessagesChannel = make(chan *ingestedMessage)
 	m.ReadReceiptsChannel = make(chan *macos.ReadReceipt)
 
 	watcher, err := fsnotify.NewWatcher()
@@ -99,7 +121,7 @@ func (m *MessagesClient) Connect(ctx context.Context) {
 		return
 	}
 
-	initialMaxMessagesTimestamp, err := m.MacOSMessagesClient.GetMaxMessagesTime()
+	initialCursor, err := m.getInitialIngestCursor()
 	if err != nil {
 		m.UserLogin.BridgeState.Send(status.BridgeState{
 			StateEvent: status.StateUnknownError,
@@ -112,7 +134,7 @@ func (m *MessagesClient) Connect(ctx context.Context) {
 
 	go func() {
 		defer watcher.Close()
-		err := m.watchMessagesDBFile(watcher, *initialMaxMessagesTimestamp)
+		err := m.watchMessagesDBFile(watcher, initialCursor)
 		if err != nil {
 			m.UserLogin.BridgeState.Send(status.BridgeState{
 				StateEvent: status.StateUnknownError,
@@ -124,11 +146,14 @@ func (m *MessagesClient) Connect(ctx context.Context) {
 	}()
 
 	go m.handleMessagesLoop()
+	go m.checkOutgoingLoop(outgoingCheckInterval)
+	go m.syncRecentChats(m.UserLogin.Log.WithContext(context.Background()))
 }
 
 func (m *MessagesClient) Disconnect() {
 	m.MessagesDBWatcherStopChannel <- struct{}{}
 	m.HandleMessagesStopChannel <- struct{}{}
+	m.OutgoingCheckStopChannel <- struct{}{}
 }
 
 func (m *MessagesClient) IsLoggedIn() bool {
@@ -146,12 +171,16 @@ func (m *MessagesClient) IsThisUser(ctx context.Context, userID networkid.UserID
 }
 
 func (m *MessagesClient) GetChatInfo(ctx context.Context, portal *bridgev2.Portal) (*bridgev2.ChatInfo, error) {
-	chatName, avatar, err := m.MacOSMessagesClient.GetChatDetails(portal.ID)
+	_, chatGUID, err := macos.ParseMessagesPortalID(portal.ID)
+	if err != nil {
+		return nil, err
+	}
+	chatName, avatar, err := m.MacOSMessagesClient.GetChatDetails(chatGUID)
 	if err != nil {
 		m.UserLogin.Log.Error().Msgf("Failed to get chat details for group %s: %s", portal.ID, err)
 		return nil, err
 	}
-	memberMap, err := m.MacOSMessagesClient.GetChatMemberMap(portal.ID, networkid.UserID(m.UserLogin.ID))
+	memberMap, err := m.MacOSMessagesClient.GetChatMemberMap(chatGUID, networkid.UserID(m.UserLogin.ID))
 	if err != nil {
 		m.UserLogin.Log.Error().Msgf("failed to get chat members for group %s: %s", portal.ID, err)
 		return nil, err
@@ -178,6 +207,7 @@ func (m *MessagesClient) GetChatInfo(ctx context.Context, portal *bridgev2.Porta
 			IsFull:    true,
 			MemberMap: memberMap,
 		},
+		CanBackfill: true,
 	}, nil
 }
 
@@ -185,16 +215,89 @@ func (m *MessagesClient) GetUserInfo(ctx context.Context, ghost *bridgev2.Ghost)
 	return m.MacOSContactsClient.GetContactUserInfo(string(ghost.ID))
 }
 
-// HandleMatrixMessage implements bridgev2.NetworkAPI.
-func (m *MessagesClient) HandleMatrixMessage(ctx context.Context, msg *bridgev2.MatrixMessage) (message *bridgev2.MatrixMessageResponse, err error) {
-	panic("unimplemented")
+// ingestedMessage is a message read from chat.db by the watcher.
+type ingestedMessage struct {
+	*macos.Message
+	// Whether the message was sent after the cursor it was read from, so it's new to us even if it was already edited or unsent
+	isNew bool
+	batch *ingestBatch
+}
+
+// ingestBatch is the messages read from chat.db at once. It counts the messages being handled and the events queued for them,
+// and calls onDone with the cursor after the batch once all of them are done.
+type ingestBatch struct {
+	cursor    macos.MessageCursor
+	remaining atomic.Int64
+	onDone    func(macos.MessageCursor)
+}
+
+func (b *ingestBatch) add() {
+	b.remaining.Add(1)
+}
+
+func (b *ingestBatch) done() {
+	if b.remaining.Add(-1) == 0 {
+		b.onDone(b.cursor)
+	}
 }
 
-func (m *MessagesClient) watchMessagesDBFile(watcher *fsnotify.Watcher, maxMessagesTimestamp int64) error {
+func (m *MessagesClient) watchMessagesDBFile(watcher *fsnotify.Watcher, cursor macos.MessageCursor) error {
 	var skipEvents bool
 	var handleLock sync.Mutex
 	nonSentMessages := make(map[string]bool)
 	minReceiptTime := time.Now()
+	readChanges := func() {
+		handleLock.Lock()
+		defer handleLock.Unlock()
+
+		if newMessages, err := m.MacOSMessagesClient.GetMessagesAfterIngestCursor(cursor); err != nil {
+			m.UserLogin.Log.Warn().Msgf("Error reading messages after fsevent: %v", err)
+		} else if len(newMessages) > 0 {
+			readFrom := cursor
+			batch := &ingestBatch{onDone: func(cursor macos.MessageCursor) {
+				m.saveIngestCursor(m.UserLogin.Log.WithContext(context.Background()), cursor)
+			}}
+			// Held until every message was handed over, so the batch can't finish while they're still being read
+			batch.add()
+			for _, message := range newMessages {
+				cursor = message.GetIngestCursor()
+
+				if !message.IsSent {
+					nonSentMessages[message.GUID] = true
+				} else if _, ok := nonSentMessages[message.GUID]; ok {
+					delete(nonSentMessages, message.GUID)
+					continue
+				}
+
+				batch.add()
+				m.MessagesChannel <- &ingestedMessage{
+					Message: message,
+					isNew:   message.GetCursor().After(readFrom),
+					batch:   batch,
+				}
+			}
+			batch.cursor = cursor
+			batch.done()
+		}
+		if m.Config.Features.ReadReceipts {
+			var latestReadReceipts []*macos.ReadReceipt
+			var err error
+			if latestReadReceipts, minReceiptTime, err = m.MacOSMessagesClient.GetReadReceiptsSince(minReceiptTime); err != nil {
+				m.UserLogin.Log.Warn().Msgf("error reading receipts after fsevent: %v", err)
+			} else {
+				for _, readReceipt := range latestReadReceipts {
+					m.ReadReceiptsChannel <- readReceipt
+				}
+			}
+		}
+		m.checkOutgoingMessages(m.UserLogin.Log.WithContext(context.Background()))
+
+		skipEvents = false
+	}
+
+	// Catch up on whatever arrived while we weren't running
+	skipEvents = true
+	go readChanges()
 	for {
 		select {
 		case <-m.MessagesDBWatcherStopChannel:
@@ -209,42 +312,49 @@ func (m *MessagesClient) watchMessagesDBFile(watcher *fsnotify.Watcher, maxMessa
 				continue
 			}
 
+			// Wait for the rest of a burst of writes so they're all read at once
 			skipEvents = true
-			go func() {
-				handleLock.Lock()
-				defer handleLock.Unlock()
+			time.AfterFunc(m.Config.WatcherDebounce, readChanges)
+		}
+	}
+}
 
-				if newMessages, err := m.MacOSMessagesClient.GetMessagesNewerThan(maxMessagesTimestamp); err != nil {
-					m.UserLogin.Log.Warn().Msgf("Error reading messages after fsevent: %v", err)
-				} else {
-					for _, message := range newMessages {
-						if message.Date > maxMessagesTimestamp {
-							maxMessagesTimestamp = message.Date
-						}
-
-						if !message.IsSent {
-							nonSentMessages[message.GUID] = true
-						} else if _, ok := nonSentMessages[message.GUID]; ok {
-							delete(nonSentMessages, message.GUID)
-							continue
-						}
-
-						m.MessagesChannel <- message
-					}
-				}
-				var latestReadReceipts []*macos.ReadReceipt
-				var err error
-				if latestReadReceipts, minReceiptTime, err = m.MacOSMessagesClient.GetReadReceiptsSince(minReceiptTime); err != nil {
-					m.UserLogin.Log.Warn().Msgf("error reading receipts after fsevent: %v", err)
-				} else {
-					for _, readReceipt := range latestReadReceipts {
-						m.ReadReceiptsChannel <- readReceipt
-					}
-				}
+// getInitialIngestCursor picks up where the last run stopped, but no further back than the catch-up window.
+// On the first connect there is nothing to catch up on, so it starts at the latest change in chat.db.
+func (m *MessagesClient) getInitialIngestCursor() (macos.MessageCursor, error) {
+	latestDate, err := m.MacOSMessagesClient.GetMaxMessagesTime()
+	if err != nil {
+		return macos.MessageCursor{}, err
+	}
+	latest := macos.MessageCursor{Date: *latestDate, RowID: math.MaxInt}
 
-				skipEvents = false
-			}()
-		}
+	meta := m.UserLogin.Metadata.(*UserLoginMetadata)
+	if meta.IngestCursor == nil {
+		return latest, nil
+	}
+	cursor := *meta.IngestCursor
+	catchUpWindow := m.Config.CatchUpWindow
+	if catchUpWindow > 0 && latest.Date-cursor.Date > catchUpWindow.Nanoseconds() {
+		m.UserLogin.Log.Warn().Msgf("Skipping messages older than the catch-up window of %s", catchUpWindow)
+		cursor = macos.MessageCursor{Date: latest.Date - catchUpWindow.Nanoseconds()}
+	}
+	return cursor, nil
+}
+
+// saveIngestCursor persists the position of the last handled message, so a restart neither loses nor replays messages.
+func (m *MessagesClient) saveIngestCursor(ctx context.Context, cursor macos.MessageCursor) {
+	m.ingestCursorLock.Lock()
+	defer m.ingestCursorLock.Unlock()
+	meta := m.UserLogin.Metadata.(*UserLoginMetadata)
+	if meta.IngestCursor != nil && !cursor.After(*meta.IngestCursor) {
+		return
+	}
+	meta.IngestCursor = &cursor
+	if m.DryRun {
+		return
+	}
+	if err := m.UserLogin.Save(ctx); err != nil {
+		m.UserLogin.Log.Warn().Msgf("Failed to save ingest cursor: %v", err)
 	}
 }
 
@@ -260,7 +370,10 @@ func (m *MessagesClient) handleMessagesLoop() {
 		case message := <-m.MessagesChannel:
 			start = time.Now()
 			thing = "iMessage"
-			err = m.HandleiMessage(message)
+			m.setHandlingBatch(message.batch)
+			err = m.HandleiMessage(message.Message, message.isNew)
+			m.setHandlingBatch(nil)
+			message.batch.done()
 		case readReciept := <-m.ReadReceiptsChannel:
 			start = time.Now()
 			thing = "read reciept"
@@ -282,12 +395,55 @@ func (m *MessagesClient) handleMessagesLoop() {
 }
 
 func (m *MessagesClient) PortalKeyFromMessage(message *macos.Message) networkid.PortalKey {
+	return m.PortalKeyFromChatGUID(message.ChatGUID)
+}
+
+func (m *MessagesClient) PortalKeyFromChatGUID(chatGUID string) networkid.PortalKey {
 	return networkid.PortalKey{
-		ID:       macos.MakeMessagesPortalID(m.UserLogin.ID, message.ChatGUID),
+		ID:       macos.MakeMessagesPortalID(m.UserLogin.ID, chatGUID),
 		Receiver: m.UserLogin.ID,
 	}
 }
 
+func (m *MessagesClient) setHandlingBatch(batch *ingestBatch) {
+	m.handlingBatchLock.Lock()
+	defer m.handlingBatchLock.Unlock()
+	m.handlingBatch = batch
+}
+
+// trackInBatch counts the event towards the batch being handled until the bridge has handled it.
+// Events the bridge drops without handling, like ones for chats without a room, keep the batch from being saved,
+// so it's read again after a restart, where the bridge skips the messages it already has.
+func (m *MessagesClient) trackInBatch(evt bridgev2.RemoteEvent) {
+	m.handlingBatchLock.Lock()
+	batch := m.handlingBatch
+	m.handlingBatchLock.Unlock()
+	if batch == nil {
+		return
+	}
+	var meta *simplevent.EventMeta
+	switch typedEvt := evt.(type) {
+	case *simplevent.Message[macos.Message]:
+		meta = &typedEvt.EventMeta
+	case *simplevent.MessageRemove:
+		meta = &typedEvt.EventMeta
+	case *simplevent.ChatInfoChange:
+		meta = &typedEvt.EventMeta
+	case *partReactionSync:
+		meta = &typedEvt.EventMeta
+	default:
+		return
+	}
+	postHandle := meta.PostHandleFunc
+	meta.PostHandleFunc = func(ctx context.Context, portal *bridgev2.Portal) {
+		if postHandle != nil {
+			postHandle(ctx, portal)
+		}
+		batch.done()
+	}
+	batch.add()
+}
+
 func (m *MessagesClient) QueueRemoteEventWrapper(evt bridgev2.RemoteEvent) {
 	if m.DryRun {
 		// m.UserLogin.Log.Info().Msgf("would send event: %s", evt.GetType())
@@ -303,7 +459,7 @@ func (m *MessagesClient) QueueRemoteEventWrapper(evt bridgev2.RemoteEvent) {
 			if asMessageEvent.ConvertMessageFunc != nil {
 				if len(asMessageEvent.Data.CombinedComponents) > 1 {
 					m.UserLogin.Log.Info().Msgf("original:\n%s", asMessageEvent.Data)
-					convertResult, err := asMessageEvent.ConvertMessageFunc(context, portal, &macos.MockMatrixAPI{}, asMessageEvent.Data)
+					convertResult, err := asMessageEvent.ConvertMessageFunc(context, portal, dryRunMatrixAPI{}, asMessageEvent.Data)
 					if err != nil {
 						m.UserLogin.Log.Error().Msgf("error converting message: %v", err)
 					}
@@ -311,54 +467,108 @@ func (m *MessagesClient) QueueRemoteEventWrapper(evt bridgev2.RemoteEvent) {
 				}
 			} else if asMessageEvent.ConvertEditFunc != nil {
 				m.UserLogin.Log.Info().Msgf("[EDITED] original:\n%s", asMessageEvent.Data)
-				convertResult, err := asMessageEvent.ConvertEditFunc(context, portal, &macos.MockMatrixAPI{}, []*database.Message{}, asMessageEvent.Data)
+				convertResult, err := asMessageEvent.ConvertEditFunc(context, portal, dryRunMatrixAPI{}, []*database.Message{}, asMessageEvent.Data)
 				if err != nil {
 					m.UserLogin.Log.Error().Msgf("error converting message: %v", err)
+				} else {
+					m.UserLogin.Log.Info().Msgf(macos.ConvertEditToString(convertResult))
 				}
-				m.UserLogin.Log.Info().Msgf(macos.ConvertEditToString(convertResult))
-			} else if asReactionEvent, ok := evt.(*simplevent.ReactionSync); ok {
-				m.UserLogin.Log.Info().Msgf("ReactionSync: %d reactions", len(asReactionEvent.Reactions.Users))
 			}
+		} else if asReactionEvent, ok := evt.(*partReactionSync); ok {
+			m.UserLogin.Log.Info().Msgf("ReactionSync: %d reactions to part %s", len(asReactionEvent.Reactions.Users), asReactionEvent.TargetPart)
 		}
 
 		return
 	}
+	m.trackInBatch(evt)
 	m.UserLogin.Bridge.QueueRemoteEvent(m.UserLogin, evt)
 }
 
+// partReactionSync syncs the reactions to one part of a message, which is what a tapback is on.
+type partReactionSync struct {
+	*simplevent.ReactionSync
+	TargetPart networkid.PartID
+}
+
+var _ bridgev2.RemoteEventWithTargetPart = (*partReactionSync)(nil)
+var _ bridgev2.RemotePreHandler = (*partReactionSync)(nil)
+
+func (evt *partReactionSync) GetTargetMessagePart() networkid.PartID {
+	return evt.TargetPart
+}
+
+// PreHandle moves a tapback on a part that wasn't bridged to the first part, like backfilled tapbacks.
+// It runs when the event is handled, so the messages queued before the tapback are saved by then.
+func (evt *partReactionSync) PreHandle(ctx context.Context, portal *bridgev2.Portal) {
+	if partIndex, err := strconv.Atoi(string(evt.TargetPart)); err == nil {
+		evt.TargetPart = *getTargetPartID(ctx, portal, evt.TargetMessage, partIndex)
+	}
+}
+
 func (m *MessagesClient) HandleTapback(message *macos.Message) {
+	sender := bridgev2.EventSender{
+		Sender:   networkid.UserID(message.Sender.LocalID),
+		IsFromMe: message.IsFromMe,
+	}
 	reactions := []*bridgev2.BackfillReaction{}
 
 	if !message.Tapback.Remove {
 		emoji := message.Tapback.GetEmoji()
 		reactions = append(reactions, &bridgev2.BackfillReaction{
 			Timestamp: message.CreatedAt,
+			Sender:    sender,
 			Emoji:     emoji,
 			EmojiID:   networkid.EmojiID(emoji),
 		})
 	}
 
-	m.QueueRemoteEventWrapper(&simplevent.ReactionSync{
-		EventMeta: simplevent.EventMeta{
-			Type: bridgev2.RemoteEventReactionSync,
-			LogContext: func(c zerolog.Context) zerolog.Context {
-				return c.Str("message_guid", message.GUID)
+	m.QueueRemoteEventWrapper(&partReactionSync{
+		ReactionSync: &simplevent.ReactionSync{
+			EventMeta: simplevent.EventMeta{
+				Type: bridgev2.RemoteEventReactionSync,
+				LogContext: func(c zerolog.Context) zerolog.Context {
+					return c.Str("message_guid", message.GUID).Int("target_part", message.Tapback.TargetPart)
+				},
+				PortalKey: m.PortalKeyFromMessage(message),
+				Sender:    sender,
 			},
-			PortalKey: m.PortalKeyFromMessage(message),
-		},
-		TargetMessage: networkid.MessageID(message.Tapback.TargetGUID),
-		Reactions: &bridgev2.ReactionSyncData{
-			Users: map[networkid.UserID]*bridgev2.ReactionSyncUser{
-				networkid.UserID(message.Sender.String()): {
-					HasAllReactions: true,
-					Reactions:       reactions,
+			TargetMessage: networkid.MessageID(message.Tapback.TargetGUID),
+			Reactions: &bridgev2.ReactionSyncData{
+				Users: map[networkid.UserID]*bridgev2.ReactionSyncUser{
+					sender.Sender: {
+						HasAllReactions: true,
+						Reactions:       reactions,
+					},
 				},
 			},
 		},
+		TargetPart: message.Tapback.GetTargetPartID(),
 	})
 }
 
+// HandleRetraction redacts every part of a message that was unsent as a whole, replacing it with a notice if enabled.
 func (m *MessagesClient) HandleRetraction(message *macos.Message) {
+	if m.sendUnsentNotices() {
+		m.QueueRemoteEventWrapper(&simplevent.Message[macos.Message]{
+			EventMeta: simplevent.EventMeta{
+				Sender: bridgev2.EventSender{
+					Sender:   networkid.UserID(message.Sender.LocalID),
+					IsFromMe: message.IsFromMe,
+				},
+				Type: bridgev2.RemoteEventEdit,
+				LogContext: func(c zerolog.Context) zerolog.Context {
+					return c.Str("message_guid", message.GUID)
+				},
+				PortalKey: m.PortalKeyFromMessage(message),
+				Timestamp: retractedTimestamp(message),
+			},
+			TargetMessage:   networkid.MessageID(message.GUID),
+			ID:              networkid.MessageID(message.GUID),
+			Data:            *message,
+			ConvertEditFunc: m.ConvertRetraction,
+		})
+		return
+	}
 	m.QueueRemoteEventWrapper(&simplevent.MessageRemove{
 		EventMeta: simplevent.EventMeta{
 			Type: bridgev2.RemoteEventMessageRemove,
@@ -366,13 +576,16 @@ func (m *MessagesClient) HandleRetraction(message *macos.Message) {
 				return c.Str("message_guid", message.GUID)
 			},
 			PortalKey: m.PortalKeyFromMessage(message),
+			Timestamp: retractedTimestamp(message),
 		},
 		TargetMessage: networkid.MessageID(message.GUID),
 		// OnlyForMe: true,
 	})
 }
 
+// HandleEdit bridges each edit of the message that wasn't bridged yet, followed by parts that were unsent.
 func (m *MessagesClient) HandleEdit(message *macos.Message) {
+	m.queuePartEdits(message)
 	m.QueueRemoteEventWrapper(&simplevent.Message[macos.Message]{
 		EventMeta: simplevent.EventMeta{
 			Sender: bridgev2.EventSender{
@@ -383,18 +596,21 @@ func (m *MessagesClient) HandleEdit(message *macos.Message) {
 			LogContext: func(c zerolog.Context) zerolog.Context {
 				return c.Str("message_guid", message.GUID)
 			},
-			PortalKey:    m.PortalKeyFromMessage(message),
-			CreatePortal: true,
-			Timestamp:    time.Now(),
+			PortalKey:     m.PortalKeyFromMessage(message),
+			CreatePortal:  true,
+			Timestamp:     editedTimestamp(message),
+			PreHandleFunc: m.rekeyLegacyPartsFunc(message),
 		},
 		TargetMessage:   networkid.MessageID(message.GUID),
 		ID:              networkid.MessageID(message.GUID),
 		Data:            *message,
-		ConvertEditFunc: ConvertEditMessage,
+		ConvertEditFunc: m.ConvertEditMessage,
 	})
 }
 
 func (m *MessagesClient) HandleNormalMessage(message *macos.Message) {
+	// Messages sent from Matrix are matched to their pending event rather than bridged again
+	transactionID := m.matchPendingSend(message)
 	m.QueueRemoteEventWrapper(&simplevent.Message[macos.Message]{
 		EventMeta: simplevent.EventMeta{
 			Sender: bridgev2.EventSender{
@@ -410,66 +626,156 @@ func (m *MessagesClient) HandleNormalMessage(message *macos.Message) {
 			Timestamp:    time.Now(),
 		},
 		ID:                 networkid.MessageID(message.GUID),
-		ConvertMessageFunc: ConvertMessage,
+		TransactionID:      transactionID,
+		ConvertMessageFunc: m.ConvertMessage,
 		Data:               *message,
 	})
 }
 
-func ConvertEditMessage(ctx context.Context, portal *bridgev2.Portal, intent bridgev2.MatrixAPI, existing []*database.Message, data macos.Message) (*bridgev2.ConvertedEdit, error) {
-	editParts, err := data.ConvertMessageToParts(ctx, intent, portal.MXID)
+// ConvertEditMessage deletes the parts that were unsent, matched to the existing parts by part ID, and adds
+// edited parts that weren't bridged before. Edits of existing parts are bridged one by one by queuePartEdits.
+// Unsent parts are replaced with a notice if enabled.
+func (m *MessagesClient) ConvertEditMessage(ctx context.Context, portal *bridgev2.Portal, intent bridgev2.MatrixAPI, existing []*database.Message, data macos.Message) (*bridgev2.ConvertedEdit, error) {
+	editParts, err := data.ConvertMessageToParts(ctx, intent, portal.MXID, m.resolveMention)
 	if err != nil {
 		return nil, fmt.Errorf("converting data message to parts: %w", err)
 	}
-	modifiedParts := []*bridgev2.ConvertedEditPart{}
-	deletedParts := []*database.Message{}
-	addedParts := &bridgev2.ConvertedMessage{
-		Parts: []*bridgev2.ConvertedMessagePart{},
+	editPartsByID := make(map[networkid.PartID]*bridgev2.ConvertedMessagePart, len(editParts))
+	for _, editPart := range editParts {
+		editPartsByID[editPart.ID] = editPart
+	}
+	existingByID := make(map[networkid.PartID]*database.Message, len(existing))
+	for _, existingPart := range existing {
+		existingByID[existingPart.PartID] = existingPart
 	}
 
-	// This is almost certainly wrong, but who knows...
-	for existingIndex := 0; existingIndex < len(editParts) && existingIndex < len(existing); existingIndex++ {
-		modifiedParts = append(modifiedParts, editParts[existingIndex].ToEditPart(existing[existingIndex]))
+	convertedEdit := &bridgev2.ConvertedEdit{}
+	addedParts := []*bridgev2.ConvertedMessagePart{}
+	addPart := func(editPart *bridgev2.ConvertedMessagePart) {
+		if existingPart, ok := existingByID[editPart.ID]; ok {
+			convertedEdit.ModifiedParts = append(convertedEdit.ModifiedParts, editPart.ToEditPart(existingPart))
+		} else {
+			addedParts = append(addedParts, editPart)
+		}
 	}
-	if len(existing) < len(editParts) {
-		addedParts.Parts = editParts[len(existing):]
-	} else if len(editParts) < len(existing) {
-		deletedParts = existing[len(editParts):]
+	if len(data.EditedMessageParts) == 0 {
+		// Without message_summary_info we can't tell which parts changed, so all of them are updated
+		for _, editPart := range editParts {
+			addPart(editPart)
+		}
 	}
-
-	return &bridgev2.ConvertedEdit{
-		ModifiedParts: modifiedParts,
-		DeletedParts:  deletedParts,
-		AddedParts:    addedParts,
-	}, nil
+	for index, editedMessagePart := range data.EditedMessageParts {
+		partID := networkid.PartID(strconv.Itoa(index))
+		switch editedMessagePart.Status {
+		case macos.EditedMessageStatusEdited:
+			if editPart, ok := editPartsByID[partID]; ok && existingByID[partID] == nil {
+				addPart(editPart)
+			}
+		case macos.EditedMessageStatusUnsent:
+			if existingPart, ok := existingByID[partID]; ok && !isUnsentNotice(existingPart) {
+				convertedEdit.DeletedParts = append(convertedEdit.DeletedParts, existingPart)
+				if m.sendUnsentNotices() {
+					addedParts = append(addedParts, m.convertUnsentNoticePart(data, partID, "this message part"))
+				}
+			}
+		}
+	}
+	if len(addedParts) > 0 {
+		markIndexedPartIDs(addedParts)
+		convertedEdit.AddedParts = &bridgev2.ConvertedMessage{
+			Parts: addedParts,
+		}
+	}
+	return convertedEdit, nil
 }
 
-func ConvertMessage(ctx context.Context, portal *bridgev2.Portal, intent bridgev2.MatrixAPI, data macos.Message) (*bridgev2.ConvertedMessage, error) {
+func (m *MessagesClient) ConvertMessage(ctx context.Context, portal *bridgev2.Portal, intent bridgev2.MatrixAPI, data macos.Message) (*bridgev2.ConvertedMessage, error) {
+	// The reply target is the message that started the thread, which is also what threads are rooted on
 	var replyTo *networkid.MessageOptionalPartID
+	var threadRoot *networkid.MessageID
 	if data.ReplyToGUID != "" {
-		replyTo = &networkid.MessageOptionalPartID{
-			MessageID: networkid.MessageID(data.ReplyToGUID),
+		inlineReplies := m.getInlineReplyMode()
+		if inlineReplies != InlineReplyModeReply {
+			threadRootID := networkid.MessageID(data.ReplyToGUID)
+			threadRoot = &threadRootID
+		}
+		if inlineReplies != InlineReplyModeThread {
+			replyTo = &networkid.MessageOptionalPartID{
+				MessageID: networkid.MessageID(data.ReplyToGUID),
+				PartID:    getTargetPartID(ctx, portal, networkid.MessageID(data.ReplyToGUID), data.ReplyToPart),
+			}
 		}
 	}
-	parts, err := data.ConvertMessageToParts(ctx, intent, portal.MXID)
+	parts, err := data.ConvertMessageToParts(ctx, intent, portal.MXID, m.resolveMention)
 	if err != nil {
 		return nil, fmt.Errorf("converting data message to parts: %w", err)
 	}
+	parts = m.convertUnsentParts(data, parts)
+	markIndexedPartIDs(parts)
+	markBridgedEdits(data, parts)
 	return &bridgev2.ConvertedMessage{
-		ReplyTo: replyTo,
-		Parts:   parts,
+		ReplyTo:    replyTo,
+		ThreadRoot: threadRoot,
+		Parts:      parts,
 	}, nil
 }
 
-func (m *MessagesClient) HandleMessage(message *macos.Message) {
+// getInlineReplyMode returns the configured InlineReplyMode, treating unknown values as replies.
+func (m *MessagesClient) getInlineReplyMode() InlineReplyMode {
+	if m.Config == nil {
+		return InlineReplyModeReply
+	}
+	switch m.Config.InlineReplies {
+	case InlineReplyModeThread, InlineReplyModeThreadWithReply:
+		return m.Config.InlineReplies
+	default:
+		return InlineReplyModeReply
+	}
+}
+
+// getTargetPartID returns the ID of the part a reply or tapback is on, numbered like ConvertMessageToParts numbers them.
+// Replies and tapbacks on a part we don't have, e.g. one that was unsent, go to the first part instead.
+func getTargetPartID(ctx context.Context, portal *bridgev2.Portal, messageID networkid.MessageID, partIndex int) *networkid.PartID {
+	partID := networkid.PartID(strconv.Itoa(partIndex))
+	firstPartID := networkid.PartID("0")
+	if partIndex <= 0 {
+		return &firstPartID
+	}
+	if portal.Bridge == nil {
+		return &partID
+	}
+	part, err := portal.Bridge.DB.Message.GetPartByID(ctx, portal.Receiver, messageID, partID)
+	if err != nil || part == nil {
+		return &firstPartID
+	}
+	return &partID
+}
+
+// resolveMention returns the ghost of a handle mentioned in a message, or the user's own MXID if it's one of theirs.
+func (m *MessagesClient) resolveMention(handle string) id.UserID {
+	if m.DryRun || handle == "" {
+		return ""
+	}
+	userID := networkid.UserID(handle)
+	if m.IsThisUser(context.Background(), userID) {
+		return m.UserLogin.UserMXID
+	}
+	return m.UserLogin.Bridge.Matrix.GhostIntent(userID).GetMXID()
+}
+
+// HandleMessage bridges a message according to what changed. Messages that are new to us are sent as they are now,
+// even if they were edited or partly unsent before we read them, e.g. while the bridge wasn't running.
+func (m *MessagesClient) HandleMessage(message *macos.Message, isNew bool) {
 	if message.Tapback != nil {
 		m.HandleTapback(message)
 		return
 	}
-	if message.IsRetracted {
+	if message.IsFullyUnsent() {
 		m.HandleRetraction(message)
 		return
 	}
-	if message.IsEdited {
+	// Messages with only some parts unsent are handled like edits, which redact those parts
+	if (message.IsEdited || message.IsRetracted) && !isNew {
 		m.HandleEdit(message)
 		return
 	}
@@ -546,12 +852,7 @@ func (m *MessagesClient) HandleAvatarOrMemberLeave(message *macos.Message) {
 							if len(message.Attachments) < 1 {
 								return nil, fmt.Errorf("no attachments found in update avatar message")
 							}
-							firstAttachment := message.Attachments[0]
-							firstAttachment.PathOnDisk, err = macos.ReplaceHomeDirectory(firstAttachment.PathOnDisk)
-							if err != nil {
-								return nil, fmt.Errorf("getting avatar path: %w", err)
-							}
-							return os.ReadFile(firstAttachment.PathOnDisk)
+							return message.Attachments[0].Read()
 						},
 					},
 				},
@@ -577,10 +878,10 @@ func (m *MessagesClient) HandleAvatarOrMemberLeave(message *macos.Message) {
 	}
 }
 
-func (m *MessagesClient) HandleiMessage(message *macos.Message) error {
+func (m *MessagesClient) HandleiMessage(message *macos.Message, isNew bool) error {
 	switch message.ItemType {
 	case macos.ItemTypeMessage:
-		m.HandleMessage(message)
+		m.HandleMessage(message, isNew)
 	case macos.ItemTypeMember:
 		m.HandleMember(message)
 	case macos.ItemTypeName:
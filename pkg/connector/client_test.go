@@ -0,0 +1,373 @@
+package connector
+
+import (
+	"bytes"
+	"context"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf16"
+
+	"github.com/GroveJay/matrix-macOS-Messages-bridge/pkg/macos"
+	"github.com/GroveJay/matrix-macOS-Messages-bridge/pkg/macos/macostest"
+	"github.com/fsnotify/fsnotify"
+	"github.com/rs/zerolog"
+	"maunium.net/go/mautrix/bridgev2"
+	"maunium.net/go/mautrix/bridgev2/database"
+	"maunium.net/go/mautrix/bridgev2/networkid"
+	"maunium.net/go/mautrix/bridgev2/simplevent"
+)
+
+// partsAttributedBody encodes an attributed body with each text as its own message part.
+func partsAttributedBody(t *testing.T, texts ...string) []byte {
+	t.Helper()
+	attributedString := &macos.AttributedString{}
+	for part, text := range texts {
+		attributedString.Runs = append(attributedString.Runs, macos.AttributeRun{
+			Location:   len(utf16.Encode([]rune(attributedString.String))),
+			Length:     len(utf16.Encode([]rune(text))),
+			Attributes: macos.AttributeDictionary{{Key: string(macos.MessagePartAttributeName), Value: part}},
+		})
+		attributedString.String += text
+	}
+	attributedBody, err := macos.EncodeAttributedString(attributedString)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return attributedBody
+}
+
+// readTestMessages parses every message in the chat.db like the bridge does.
+func readTestMessages(t *testing.T, chatDB *macostest.ChatDB) []*macos.Message {
+	t.Helper()
+	log := zerolog.Nop()
+	client, err := macos.GetMessagesClient("user", &log, chatDB.Paths(), macostest.NewFakeScriptRunner())
+	if err != nil {
+		t.Fatal(err)
+	}
+	messages, err := client.GetMessagesAboveRowID(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return messages
+}
+
+func partIDs[T any](parts []T, partID func(T) networkid.PartID) []networkid.PartID {
+	partIDs := []networkid.PartID{}
+	for _, part := range parts {
+		partIDs = append(partIDs, partID(part))
+	}
+	return partIDs
+}
+
+func TestConvertEditMessage(t *testing.T) {
+	sentAt := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	editedAt := sentAt.Add(time.Minute)
+	edited := macostest.NewSummaryInfo(2).Edit(1, sentAt, "second").Edit(1, editedAt, "second, edited").Bytes()
+	unsent := macostest.NewSummaryInfo(2).Unsend(1).Bytes()
+	tests := []struct {
+		name           string
+		attributedBody []byte
+		summaryInfo    []byte
+		unsendNotices  bool
+		existing       []networkid.PartID
+		wantModified   []networkid.PartID
+		wantDeleted    []networkid.PartID
+		wantAdded      []networkid.PartID
+		wantAddedBody  string
+	}{
+		{
+			// Edits of parts that were bridged are sent one by one, see convertPartEditFunc
+			name:           "edited part that was bridged",
+			attributedBody: partsAttributedBody(t, "first", "second, edited"),
+			summaryInfo:    edited,
+			existing:       []networkid.PartID{"0", "1"},
+		},
+		{
+			name:           "edited part that wasn't bridged",
+			attributedBody: partsAttributedBody(t, "first", "second, edited"),
+			summaryInfo:    edited,
+			existing:       []networkid.PartID{"0"},
+			wantAdded:      []networkid.PartID{"1"},
+			wantAddedBody:  "second, edited",
+		},
+		{
+			// Part 0 is the only existing part by position, but part 1 is a different part
+			name:           "parts are matched by ID",
+			attributedBody: partsAttributedBody(t, "first, edited", "second"),
+			summaryInfo:    macostest.NewSummaryInfo(2).Edit(0, sentAt, "first").Edit(0, editedAt, "first, edited").Bytes(),
+			existing:       []networkid.PartID{"1"},
+			wantAdded:      []networkid.PartID{"0"},
+			wantAddedBody:  "first, edited",
+		},
+		{
+			name:           "unsent part",
+			attributedBody: partsAttributedBody(t, "first"),
+			summaryInfo:    unsent,
+			existing:       []networkid.PartID{"0", "1"},
+			wantDeleted:    []networkid.PartID{"1"},
+		},
+		{
+			name:           "unsent part with notices",
+			attributedBody: partsAttributedBody(t, "first"),
+			summaryInfo:    unsent,
+			unsendNotices:  true,
+			existing:       []networkid.PartID{"0", "1"},
+			wantDeleted:    []networkid.PartID{"1"},
+			wantAdded:      []networkid.PartID{"1"},
+			wantAddedBody:  "You unsent this message part 1 minute after sending.",
+		},
+		{
+			name:           "unsent part that was never bridged",
+			attributedBody: partsAttributedBody(t, "first"),
+			summaryInfo:    unsent,
+			existing:       []networkid.PartID{"0"},
+		},
+		{
+			// Without a summary there's no telling which parts changed
+			name:           "no summary",
+			attributedBody: partsAttributedBody(t, "first", "second"),
+			existing:       []networkid.PartID{"0"},
+			wantModified:   []networkid.PartID{"0"},
+			wantAdded:      []networkid.PartID{"1"},
+			wantAddedBody:  "second",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			chatDB := macostest.NewChatDB(t)
+			// Sent by us, so notices don't need the sender's ghost
+			builder := chatDB.DirectChat(chatDB.Handle("+15550001111", "iMessage")).Message().FromMe().At(sentAt).AttributedBody(test.attributedBody)
+			if test.summaryInfo != nil {
+				builder = builder.Edited(editedAt, test.summaryInfo)
+			}
+			builder.Insert()
+			messages := readTestMessages(t, chatDB)
+			if len(messages) != 1 {
+				t.Fatalf("got %d messages, want 1", len(messages))
+			}
+
+			client, portal := newTestPortal(t)
+			client.Config = &Config{}
+			client.Config.Features.UnsendNotices = test.unsendNotices
+			existing := insertTestParts(t, portal, networkid.MessageID(messages[0].GUID), func() *MessageMetadata {
+				return &MessageMetadata{IndexedPartID: true}
+			}, test.existing...)
+			intent := macostest.NewFakeMatrixAPI("")
+			converted, err := client.ConvertEditMessage(context.Background(), portal, intent, existing, *messages[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			modified := partIDs(converted.ModifiedParts, func(part *bridgev2.ConvertedEditPart) networkid.PartID { return part.Part.PartID })
+			if !slices.Equal(modified, test.wantModified) {
+				t.Errorf("modified parts = %v, want %v", modified, test.wantModified)
+			}
+			deleted := partIDs(converted.DeletedParts, func(part *database.Message) networkid.PartID { return part.PartID })
+			if !slices.Equal(deleted, test.wantDeleted) {
+				t.Errorf("deleted parts = %v, want %v", deleted, test.wantDeleted)
+			}
+			var addedParts []*bridgev2.ConvertedMessagePart
+			if converted.AddedParts != nil {
+				addedParts = converted.AddedParts.Parts
+			}
+			added := partIDs(addedParts, func(part *bridgev2.ConvertedMessagePart) networkid.PartID { return part.ID })
+			if !slices.Equal(added, test.wantAdded) {
+				t.Fatalf("added parts = %v, want %v", added, test.wantAdded)
+			}
+			for _, part := range addedParts {
+				if part.Content.Body != test.wantAddedBody {
+					t.Errorf("added part body = %q, want %q", part.Content.Body, test.wantAddedBody)
+				}
+				if metadata, ok := part.DBMetadata.(*MessageMetadata); !ok || !metadata.IndexedPartID {
+					t.Errorf("added part %s isn't marked as having an indexed part ID", part.ID)
+				}
+			}
+		})
+	}
+}
+
+func TestPartReactionSyncPreHandle(t *testing.T) {
+	tests := []struct {
+		name     string
+		existing []networkid.PartID
+		want     networkid.PartID
+	}{
+		{"bridged part", []networkid.PartID{"0", "1"}, "1"},
+		{"part that wasn't bridged", []networkid.PartID{"0"}, "0"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, portal := newTestPortal(t)
+			insertTestParts(t, portal, "msg", func() *MessageMetadata {
+				return &MessageMetadata{IndexedPartID: true}
+			}, test.existing...)
+			evt := &partReactionSync{
+				ReactionSync: &simplevent.ReactionSync{TargetMessage: "msg"},
+				TargetPart:   "1",
+			}
+			evt.PreHandle(context.Background(), portal)
+			if got := evt.GetTargetMessagePart(); got != test.want {
+				t.Errorf("target part = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestHandleTapbackDryRun(t *testing.T) {
+	chatDB := macostest.NewChatDB(t)
+	handle := chatDB.Handle("+15550001111", "iMessage")
+	chat := chatDB.DirectChat(handle)
+	target := chat.Message().From(handle).AttributedBody(partsAttributedBody(t, "first", "second")).Insert()
+	chat.Message().FromMe().Tapback(target, 1, macos.TapbackLove).Insert()
+	messages := readTestMessages(t, chatDB)
+	if len(messages) != 2 || messages[1].Tapback == nil {
+		t.Fatalf("got %d messages, want a message and a tapback on it", len(messages))
+	}
+
+	client, _ := newTestPortal(t)
+	var logs bytes.Buffer
+	client.UserLogin.Log = zerolog.New(&logs)
+	client.DryRun = true
+	client.HandleTapback(messages[1])
+	if want := "ReactionSync: 1 reactions to part 1"; !strings.Contains(logs.String(), want) {
+		t.Errorf("logs = %q, want them to contain %q", logs.String(), want)
+	}
+}
+
+// readTestEditedMessage writes a message with two parts whose second part was edited, and returns it parsed.
+func readTestEditedMessage(t *testing.T) *macos.Message {
+	t.Helper()
+	sentAt := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	chatDB := macostest.NewChatDB(t)
+	chatDB.DirectChat(chatDB.Handle("+15550001111", "iMessage")).Message().FromMe().At(sentAt).
+		AttributedBody(partsAttributedBody(t, "first", "second, edited")).
+		Edited(sentAt.Add(time.Minute), macostest.NewSummaryInfo(2).
+			Edit(1, sentAt, "second").
+			Edit(1, sentAt.Add(time.Minute), "second, edited").
+			Bytes()).
+		Insert()
+	messages := readTestMessages(t, chatDB)
+	if len(messages) != 1 || !messages[0].IsEdited {
+		t.Fatalf("got %d messages, want one edited message", len(messages))
+	}
+	return messages[0]
+}
+
+func TestHandleMessageEditedBeforeRead(t *testing.T) {
+	tests := []struct {
+		name     string
+		isNew    bool
+		wantLogs string
+		notLogs  string
+	}{
+		{"new message", true, "original:", "[EDITED]"},
+		{"message seen before", false, "[EDITED] original:", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			message := readTestEditedMessage(t)
+			client, _ := newTestPortal(t)
+			var logs bytes.Buffer
+			client.UserLogin.Log = zerolog.New(&logs)
+			client.DryRun = true
+			client.HandleMessage(message, test.isNew)
+			if !strings.Contains(logs.String(), test.wantLogs) {
+				t.Errorf("logs = %q, want them to contain %q", logs.String(), test.wantLogs)
+			}
+			if test.notLogs != "" && strings.Contains(logs.String(), test.notLogs) {
+				t.Errorf("logs = %q, want them not to contain %q", logs.String(), test.notLogs)
+			}
+		})
+	}
+}
+
+func TestConvertMessageEdited(t *testing.T) {
+	message := readTestEditedMessage(t)
+	client, portal := newTestPortal(t)
+	converted, err := client.ConvertMessage(context.Background(), portal, macostest.NewFakeMatrixAPI(""), *message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantBodies := []string{"first", "second, edited"}
+	// The edited part shows its last edit, so none of its history is left to bridge
+	wantEditCounts := []int{0, 2}
+	if len(converted.Parts) != len(wantBodies) {
+		t.Fatalf("got %d parts, want %d", len(converted.Parts), len(wantBodies))
+	}
+	for i, part := range converted.Parts {
+		if part.Content.Body != wantBodies[i] {
+			t.Errorf("part %d body = %q, want %q", i, part.Content.Body, wantBodies[i])
+		}
+		if metadata := part.DBMetadata.(*MessageMetadata); metadata.EditCount != wantEditCounts[i] {
+			t.Errorf("part %d edit count = %d, want %d", i, metadata.EditCount, wantEditCounts[i])
+		}
+	}
+}
+
+func TestIngestCursorSavedAfterHandling(t *testing.T) {
+	ctx := context.Background()
+	client, portal := newTestPortal(t)
+	chatDB := macostest.NewChatDB(t)
+	connectTestClient(t, client, chatDB)
+	// The portal looks up the sender's contact when bridging their first message
+	macostest.NewAddressBook(t, chatDB.Home)
+	connectTestContacts(t, client, chatDB)
+	handle := chatDB.Handle("+15555550123", "iMessage")
+	chat := chatDB.DirectChat(handle)
+	first := chat.Text(handle, "first")
+	last := chat.Text(handle, "last")
+
+	// Hold up the portal, so the messages are queued but not handled yet
+	release := make(chan struct{})
+	client.UserLogin.QueueRemoteEvent(&simplevent.EventMeta{
+		Type:      bridgev2.RemoteEventUnknown,
+		PortalKey: testPortalKey,
+		PreHandleFunc: func(context.Context, *bridgev2.Portal) {
+			<-release
+		},
+	})
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer watcher.Close()
+	client.MessagesDBWatcherStopChannel = make(chan struct{}, 1)
+	client.HandleMessagesStopChannel = make(chan struct{}, 1)
+	client.MessagesChannel = make(chan *ingestedMessage)
+	go client.handleMessagesLoop()
+	go client.watchMessagesDBFile(watcher, macos.MessageCursor{})
+	defer func() {
+		client.MessagesDBWatcherStopChannel <- struct{}{}
+		client.HandleMessagesStopChannel <- struct{}{}
+	}()
+
+	savedCursor := func() *macos.MessageCursor {
+		t.Helper()
+		login, err := portal.Bridge.DB.UserLogin.GetByID(ctx, testPortalKey.Receiver)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return login.Metadata.(*UserLoginMetadata).IngestCursor
+	}
+	time.Sleep(100 * time.Millisecond)
+	if cursor := savedCursor(); cursor != nil {
+		t.Fatalf("cursor %+v was saved before the messages were handled", cursor)
+	}
+
+	close(release)
+	var cursor *macos.MessageCursor
+	for deadline := time.Now().Add(5 * time.Second); cursor == nil && time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		cursor = savedCursor()
+	}
+	if want := readNewTestMessages(t, client, first.RowID)[0].GetIngestCursor(); cursor == nil || *cursor != want {
+		t.Fatalf("saved cursor = %+v, want %+v", cursor, want)
+	}
+	for _, message := range []*macostest.InsertedMessage{first, last} {
+		if parts := getTestParts(t, portal, networkid.MessageID(message.GUID)); len(parts) == 0 {
+			t.Errorf("message %s wasn't bridged before the cursor was saved", message.GUID)
+		}
+	}
+}
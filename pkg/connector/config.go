@@ -0,0 +1,93 @@
+package connector
+
+import (
+	_ "embed"
+	"time"
+
+	up "go.mau.fi/util/configupgrade"
+)
+
+//go:embed example-config.yaml
+var ExampleConfig string
+
+type Config struct {
+	DefaultRegion string `yaml:"default_region"`
+
+	Paths struct {
+		Home               string `yaml:"home"`
+		ChatDB             string `yaml:"chat_db"`
+		AddressBookSources string `yaml:"address_book_sources"`
+	} `yaml:"paths"`
+
+	WatcherDebounce      time.Duration `yaml:"watcher_debounce"`
+	CatchUpWindow        time.Duration `yaml:"catch_up_window"`
+	InitialChatSyncCount int           `yaml:"initial_chat_sync_count"`
+
+	Backfill struct {
+		MaxBatchSize int           `yaml:"max_batch_size"`
+		MaxAge       time.Duration `yaml:"max_age"`
+	} `yaml:"backfill"`
+
+	Attachments struct {
+		SpoolDir string        `yaml:"spool_dir"`
+		MaxSize  int64         `yaml:"max_size"`
+		MaxAge   time.Duration `yaml:"max_age"`
+	} `yaml:"attachments"`
+
+	DryRun bool `yaml:"dry_run"`
+
+	InlineReplies InlineReplyMode `yaml:"inline_replies"`
+
+	Features struct {
+		SendMedia      bool `yaml:"send_media"`
+		DeliveryStatus bool `yaml:"delivery_status"`
+		ReadReceipts   bool `yaml:"read_receipts"`
+		UnsendNotices  bool `yaml:"unsend_notices"`
+	} `yaml:"features"`
+}
+
+// InlineReplyMode is how replies made with iMessage's inline reply, which start a thread under the replied to message, are bridged.
+type InlineReplyMode string
+
+const (
+	InlineReplyModeReply           InlineReplyMode = "reply"
+	InlineReplyModeThread          InlineReplyMode = "thread"
+	InlineReplyModeThreadWithReply InlineReplyMode = "thread_with_reply"
+)
+
+func upgradeConfig(helper up.Helper) {
+	helper.Copy(up.Str, "default_region")
+	helper.Copy(up.Str, "paths", "home")
+	helper.Copy(up.Str, "paths", "chat_db")
+	helper.Copy(up.Str, "paths", "address_book_sources")
+	helper.Copy(up.Str, "watcher_debounce")
+	helper.Copy(up.Str, "catch_up_window")
+	helper.Copy(up.Int, "initial_chat_sync_count")
+	helper.Copy(up.Int, "backfill", "max_batch_size")
+	helper.Copy(up.Str, "backfill", "max_age")
+	helper.Copy(up.Str, "attachments", "spool_dir")
+	helper.Copy(up.Int, "attachments", "max_size")
+	helper.Copy(up.Str, "attachments", "max_age")
+	helper.Copy(up.Bool, "dry_run")
+	helper.Copy(up.Str, "inline_replies")
+	helper.Copy(up.Bool, "features", "send_media")
+	helper.Copy(up.Bool, "features", "delivery_status")
+	helper.Copy(up.Bool, "features", "read_receipts")
+	helper.Copy(up.Bool, "features", "unsend_notices")
+}
+
+func (m *MessagesConnector) GetConfig() (example string, data any, upgrader up.Upgrader) {
+	return ExampleConfig, &m.Config, &up.StructUpgrader{
+		SimpleUpgrader: upgradeConfig,
+		Blocks: [][]string{
+			{"paths"},
+			{"watcher_debounce"},
+			{"backfill"},
+			{"attachments"},
+			{"dry_run"},
+			{"inline_replies"},
+			{"features"},
+		},
+		Base: ExampleConfig,
+	}
+}
@@ -2,27 +2,59 @@ package connector
 
 import (
 	"context"
+	"fmt"
+	"os"
+	"path/filepath"
 
-	"go.mau.fi/util/configupgrade"
+	"github.com/GroveJay/matrix-macOS-Messages-bridge/pkg/macos"
 	"maunium.net/go/mautrix/bridgev2"
 	"maunium.net/go/mautrix/bridgev2/database"
 )
 
 type MessagesConnector struct {
-	br *bridgev2.Bridge
+	br     *bridgev2.Bridge
+	Config Config
+	// Runs the AppleScript used to talk to Messages and Contacts, defaults to osascript
+	ScriptRunner macos.ScriptRunner
+	// Shared by all logins, created on start if not set
+	AttachmentSpool *macos.AttachmentSpool
 }
 
 var _ bridgev2.NetworkConnector = (*MessagesConnector)(nil)
 
 func (m *MessagesConnector) Init(b *bridgev2.Bridge) {
 	m.br = b
+	if m.ScriptRunner == nil {
+		m.ScriptRunner = macos.OsascriptRunner{}
+	}
 }
 
 func (m *MessagesConnector) Start(context.Context) error {
 	m.br.Log.Info().Msg("Start")
+	if m.AttachmentSpool == nil {
+		var err error
+		if m.AttachmentSpool, err = m.newAttachmentSpool(); err != nil {
+			return fmt.Errorf("failed to create attachment spool: %w", err)
+		}
+	}
+	if err := m.AttachmentSpool.Cleanup(); err != nil {
+		m.br.Log.Warn().Msgf("Failed to clean up attachment spool: %v", err)
+	}
 	return nil
 }
 
+func (m *MessagesConnector) newAttachmentSpool() (*macos.AttachmentSpool, error) {
+	spoolDir := m.Config.Attachments.SpoolDir
+	if spoolDir == "" {
+		cacheDir, err := os.UserCacheDir()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get cache directory: %w", err)
+		}
+		spoolDir = filepath.Join(cacheDir, "matrix-macOS-Messages-bridge", "spool")
+	}
+	return macos.NewAttachmentSpool(spoolDir, m.Config.Attachments.MaxSize, m.Config.Attachments.MaxAge)
+}
+
 func (m *MessagesConnector) GetCapabilities() *bridgev2.NetworkGeneralCapabilities {
 	return &bridgev2.NetworkGeneralCapabilities{}
 }
@@ -42,15 +74,13 @@ func (m *MessagesConnector) GetName() bridgev2.BridgeName {
 	}
 }
 
-func (m *MessagesConnector) GetConfig() (example string, data any, upgrader configupgrade.Upgrader) {
-	return "", nil, configupgrade.NoopUpgrader
-}
-
 func (m *MessagesConnector) GetDBMetaTypes() database.MetaTypes {
 	return database.MetaTypes{
-		Portal:   nil,
-		Ghost:    nil,
-		Message:  nil,
+		Portal: nil,
+		Ghost:  nil,
+		Message: func() any {
+			return &MessageMetadata{}
+		},
 		Reaction: nil,
 		UserLogin: func() any {
 			return &UserLoginMetadata{}
@@ -60,12 +90,35 @@ func (m *MessagesConnector) GetDBMetaTypes() database.MetaTypes {
 
 type UserLoginMetadata struct {
 	UserID string `json:"user_id"`
+	// The last change in chat.db that was handled, see macos.Message.GetIngestCursor
+	IngestCursor *macos.MessageCursor `json:"ingest_cursor,omitempty"`
+	// Whether portals for recent chats have been created after login
+	InitialChatSyncDone bool `json:"initial_chat_sync_done,omitempty"`
+}
+
+type MessageMetadata struct {
+	// GUIDs of the attachments in a message sent from Matrix, as recorded in chat.db
+	AttachmentGUIDs []string `json:"attachment_guids,omitempty"`
+	// How many entries of the part's edit history are bridged, counting the original text as the first
+	EditCount int `json:"edit_count,omitempty"`
+	// Whether the part is the notice sent in place of an unsent message or part
+	IsUnsentNotice bool `json:"is_unsent_notice,omitempty"`
+	// Whether the part ID is the index of the part in the message, see rekeyLegacyParts
+	IndexedPartID bool `json:"indexed_part_id,omitempty"`
 }
 
 func (m *MessagesConnector) LoadUserLogin(ctx context.Context, login *bridgev2.UserLogin) (err error) {
 	login.Log.Info().Msgf("MessagesConnector.LoadUserLogin")
-	login.Client = &MessagesClient{
-		UserLogin: login,
-	}
+	login.Client = m.newClient(login)
 	return nil
 }
+
+func (m *MessagesConnector) newClient(login *bridgev2.UserLogin) *MessagesClient {
+	return &MessagesClient{
+		UserLogin:       login,
+		Config:          &m.Config,
+		ScriptRunner:    m.ScriptRunner,
+		AttachmentSpool: m.AttachmentSpool,
+		DryRun:          m.Config.DryRun,
+	}
+}
@@ -0,0 +1,101 @@
+package connector
+
+import (
+	"context"
+	"errors"
+	"os"
+	"time"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/bridgev2"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+var errDryRun = errors.New("not available in dry-run mode")
+
+// dryRunMatrixAPI is what events are converted with in dry-run mode, where they're only logged.
+// Uploads get a placeholder URI without the data going anywhere, and everything else fails.
+type dryRunMatrixAPI struct{}
+
+var _ bridgev2.MatrixAPI = dryRunMatrixAPI{}
+
+func (dryRunMatrixAPI) GetMXID() id.UserID {
+	return ""
+}
+
+func (dryRunMatrixAPI) IsDoublePuppet() bool {
+	return false
+}
+
+func (dryRunMatrixAPI) SendMessage(ctx context.Context, roomID id.RoomID, eventType event.Type, content *event.Content, extra *bridgev2.MatrixSendExtra) (*mautrix.RespSendEvent, error) {
+	return nil, errDryRun
+}
+
+func (dryRunMatrixAPI) SendState(ctx context.Context, roomID id.RoomID, eventType event.Type, stateKey string, content *event.Content, ts time.Time) (*mautrix.RespSendEvent, error) {
+	return nil, errDryRun
+}
+
+func (dryRunMatrixAPI) MarkRead(ctx context.Context, roomID id.RoomID, eventID id.EventID, ts time.Time) error {
+	return errDryRun
+}
+
+func (dryRunMatrixAPI) MarkUnread(ctx context.Context, roomID id.RoomID, unread bool) error {
+	return errDryRun
+}
+
+func (dryRunMatrixAPI) MarkTyping(ctx context.Context, roomID id.RoomID, typingType bridgev2.TypingType, timeout time.Duration) error {
+	return errDryRun
+}
+
+func (dryRunMatrixAPI) DownloadMedia(ctx context.Context, uri id.ContentURIString, file *event.EncryptedFileInfo) ([]byte, error) {
+	return nil, errDryRun
+}
+
+func (dryRunMatrixAPI) DownloadMediaToFile(ctx context.Context, uri id.ContentURIString, file *event.EncryptedFileInfo, writable bool, callback func(*os.File) error) error {
+	return errDryRun
+}
+
+func (dryRunMatrixAPI) UploadMedia(ctx context.Context, roomID id.RoomID, data []byte, fileName, mimeType string) (id.ContentURIString, *event.EncryptedFileInfo, error) {
+	return id.ContentURI{Homeserver: "dry-run.invalid", FileID: "upload"}.CUString(), nil, nil
+}
+
+func (dryRunMatrixAPI) UploadMediaStream(ctx context.Context, roomID id.RoomID, size int64, requireFile bool, cb bridgev2.FileStreamCallback) (id.ContentURIString, *event.EncryptedFileInfo, error) {
+	return "", nil, errDryRun
+}
+
+func (dryRunMatrixAPI) SetDisplayName(ctx context.Context, name string) error {
+	return errDryRun
+}
+
+func (dryRunMatrixAPI) SetAvatarURL(ctx context.Context, avatarURL id.ContentURIString) error {
+	return errDryRun
+}
+
+func (dryRunMatrixAPI) SetExtraProfileMeta(ctx context.Context, data any) error {
+	return errDryRun
+}
+
+func (dryRunMatrixAPI) CreateRoom(ctx context.Context, req *mautrix.ReqCreateRoom) (id.RoomID, error) {
+	return "", errDryRun
+}
+
+func (dryRunMatrixAPI) DeleteRoom(ctx context.Context, roomID id.RoomID, puppetsOnly bool) error {
+	return errDryRun
+}
+
+func (dryRunMatrixAPI) EnsureJoined(ctx context.Context, roomID id.RoomID) error {
+	return errDryRun
+}
+
+func (dryRunMatrixAPI) EnsureInvited(ctx context.Context, roomID id.RoomID, userID id.UserID) error {
+	return errDryRun
+}
+
+func (dryRunMatrixAPI) TagRoom(ctx context.Context, roomID id.RoomID, tag event.RoomTag, isTagged bool) error {
+	return errDryRun
+}
+
+func (dryRunMatrixAPI) MuteRoom(ctx context.Context, roomID id.RoomID, until time.Time) error {
+	return errDryRun
+}
@@ -0,0 +1,23 @@
+package connector
+
+import (
+	"go/build"
+	"slices"
+	"testing"
+)
+
+// TestNoTestSupportInBinary checks that the packages linked into the bridge don't import the test fakes,
+// which would also link in the testing package.
+func TestNoTestSupportInBinary(t *testing.T) {
+	for _, path := range []string{".", "../macos"} {
+		pkg, err := build.ImportDir(path, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, testOnly := range []string{"testing", "github.com/GroveJay/matrix-macOS-Messages-bridge/pkg/macos/macostest"} {
+			if slices.Contains(pkg.Imports, testOnly) {
+				t.Errorf("%s imports %s outside of tests", pkg.Name, testOnly)
+			}
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package connector
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/GroveJay/matrix-macOS-Messages-bridge/pkg/macos"
+	"github.com/rs/zerolog"
+	"maunium.net/go/mautrix/bridgev2"
+	"maunium.net/go/mautrix/bridgev2/database"
+	"maunium.net/go/mautrix/bridgev2/networkid"
+	"maunium.net/go/mautrix/bridgev2/simplevent"
+)
+
+// queuePartEdits queues every edit in the message's history as its own Matrix edit, in the order and at the time
+// it was made. Edits that were already bridged are skipped when they're converted, see convertPartEditFunc.
+func (m *MessagesClient) queuePartEdits(message *macos.Message) {
+	for _, partEdit := range message.GetPartEdits() {
+		m.QueueRemoteEventWrapper(&simplevent.Message[macos.Message]{
+			EventMeta: simplevent.EventMeta{
+				Sender: bridgev2.EventSender{
+					Sender:   networkid.UserID(message.Sender.LocalID),
+					IsFromMe: message.IsFromMe,
+				},
+				Type: bridgev2.RemoteEventEdit,
+				LogContext: func(c zerolog.Context) zerolog.Context {
+					return c.Str("message_guid", message.GUID).Int("part_index", partEdit.PartIndex).Int("edit_index", partEdit.EditIndex)
+				},
+				PortalKey:     m.PortalKeyFromMessage(message),
+				Timestamp:     partEdit.Edit.GetTime(),
+				PreHandleFunc: m.rekeyLegacyPartsFunc(message),
+			},
+			TargetMessage:   networkid.MessageID(message.GUID),
+			ID:              networkid.MessageID(message.GUID),
+			Data:            *message,
+			ConvertEditFunc: m.convertPartEditFunc(partEdit),
+		})
+	}
+}
+
+// convertPartEditFunc returns a converter for a single edit of a part, which records in the part's metadata
+// how much of its history has been bridged.
+func (m *MessagesClient) convertPartEditFunc(partEdit macos.PartEdit) func(context.Context, *bridgev2.Portal, bridgev2.MatrixAPI, []*database.Message, macos.Message) (*bridgev2.ConvertedEdit, error) {
+	return func(ctx context.Context, portal *bridgev2.Portal, intent bridgev2.MatrixAPI, existing []*database.Message, data macos.Message) (*bridgev2.ConvertedEdit, error) {
+		editPart := data.ConvertPartEdit(partEdit, m.resolveMention)
+		var existingPart *database.Message
+		for _, part := range existing {
+			if part.PartID == editPart.ID {
+				existingPart = part
+				break
+			}
+		}
+		if existingPart == nil {
+			return nil, bridgev2.ErrIgnoringRemoteEvent
+		}
+		metadata, ok := existingPart.Metadata.(*MessageMetadata)
+		if !ok || metadata == nil {
+			metadata = &MessageMetadata{}
+			existingPart.Metadata = metadata
+		}
+		// Parts start out showing the first entry of the history, which is the original text
+		if partEdit.EditIndex < max(metadata.EditCount, 1) {
+			return nil, bridgev2.ErrIgnoringRemoteEvent
+		}
+		metadata.EditCount = partEdit.EditIndex + 1
+		return &bridgev2.ConvertedEdit{
+			ModifiedParts: []*bridgev2.ConvertedEditPart{editPart.ToEditPart(existingPart)},
+		}, nil
+	}
+}
+
+// markBridgedEdits records that parts converted after they were edited already show their last edit,
+// so that later edits of the message don't bridge the earlier ones again.
+func markBridgedEdits(data macos.Message, parts []*bridgev2.ConvertedMessagePart) {
+	for _, part := range parts {
+		partIndex, err := strconv.Atoi(string(part.ID))
+		if err != nil || !data.IsPartEdited(partIndex) {
+			continue
+		}
+		metadata, ok := part.DBMetadata.(*MessageMetadata)
+		if !ok || metadata == nil {
+			metadata = &MessageMetadata{}
+			part.DBMetadata = metadata
+		}
+		metadata.EditCount = len(data.EditedMessageParts[partIndex].EditHistory)
+	}
+}
+
+// queueBackfilledEdits bridges the edits of messages that were backfilled as they were originally sent.
+func (m *MessagesClient) queueBackfilledEdits(messages []*macos.Message) func() {
+	return func() {
+		for _, message := range messages {
+			m.queuePartEdits(message)
+		}
+	}
+}
+
+// editedTimestamp is the time of the last change to an edited message, to order the event that applies it.
+func editedTimestamp(message *macos.Message) time.Time {
+	if message.EditedAt.IsZero() {
+		return time.Now()
+	}
+	return message.EditedAt
+}
@@ -0,0 +1,71 @@
+package connector
+
+import (
+	"context"
+	"strings"
+
+	"github.com/GroveJay/matrix-macOS-Messages-bridge/pkg/macos"
+	"maunium.net/go/mautrix/bridgev2/networkid"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/format"
+	"maunium.net/go/mautrix/id"
+)
+
+// convertMatrixText turns a Matrix message into the plain text sent to Messages, which has no formatting of its own.
+// Pills of ghosts become the contact's name or handle, and lists, quotes and code blocks are kept readable as text.
+func (m *MessagesClient) convertMatrixText(ctx context.Context, content *event.MessageEventContent) string {
+	if content.Format != event.FormatHTML || content.FormattedBody == "" {
+		return content.Body
+	}
+	var contactsMap map[networkid.UserID]macos.ContactInformation
+	parser := &format.HTMLParser{
+		TabsToSpaces:   4,
+		Newline:        "\n",
+		HorizontalLine: "\n---\n",
+		PillConverter: func(displayname, mxid, eventID string, formatContext format.Context) string {
+			if len(mxid) == 0 || mxid[0] != '@' {
+				return format.DefaultPillConverter(displayname, mxid, eventID, formatContext)
+			}
+			handle, isGhost := m.UserLogin.Bridge.Matrix.ParseGhostMXID(id.UserID(mxid))
+			if !isGhost {
+				return displayname
+			}
+			if contactsMap == nil {
+				contactsMap = m.getContactsMapForMentions()
+			}
+			if contact, ok := contactsMap[handle]; ok {
+				if name := strings.TrimSpace(macos.FullName(contact.FirstName, contact.LastName)); name != "" {
+					return name
+				}
+			}
+			return string(handle)
+		},
+		BoldConverter:          plainTextConverter,
+		ItalicConverter:        plainTextConverter,
+		StrikethroughConverter: plainTextConverter,
+		MonospaceConverter:     plainTextConverter,
+		MonospaceBlockConverter: func(code, language string, formatContext format.Context) string {
+			return strings.TrimSuffix(code, "\n")
+		},
+	}
+	return parser.Parse(content.FormattedBody, format.NewContext(ctx))
+}
+
+func plainTextConverter(text string, formatContext format.Context) string {
+	return text
+}
+
+// getContactsMapForMentions returns the contacts to name mentioned ghosts with, which is empty if they can't be read.
+func (m *MessagesClient) getContactsMapForMentions() map[networkid.UserID]macos.ContactInformation {
+	if m.MacOSContactsClient == nil {
+		return map[networkid.UserID]macos.ContactInformation{}
+	}
+	contactsMap, err := m.MacOSContactsClient.GetContactsMap()
+	if err != nil {
+		m.UserLogin.Log.Warn().Msgf("Failed to get contacts for mentions: %v", err)
+	}
+	if contactsMap == nil {
+		contactsMap = map[networkid.UserID]macos.ContactInformation{}
+	}
+	return contactsMap
+}
@@ -0,0 +1,90 @@
+package connector
+
+import (
+	"context"
+	"testing"
+
+	"github.com/GroveJay/matrix-macOS-Messages-bridge/pkg/macos/macostest"
+	"maunium.net/go/mautrix/event"
+)
+
+func TestConvertMatrixText(t *testing.T) {
+	client, _ := newTestPortal(t)
+	chatDB := macostest.NewChatDB(t)
+	addressBook := macostest.NewAddressBook(t, chatDB.Home)
+	addressBook.Contact("Alice", "Smith", "", "(555) 555-0123")
+	addressBook.Contact("", "", "Bobby", "bob@example.com")
+	connectTestContacts(t, client, chatDB)
+
+	tests := []struct {
+		name          string
+		body          string
+		formattedBody string
+		want          string
+	}{
+		{
+			name: "plain text",
+			body: "**not markdown**",
+			want: "**not markdown**",
+		},
+		{
+			name:          "ghost pill with a contact",
+			formattedBody: `hi <a href="https://matrix.to/#/@messages_+15555550123:example.com">+15555550123</a>!`,
+			want:          "hi Alice Smith!",
+		},
+		{
+			// Nicknames aren't used for names, so the handle is
+			name:          "ghost pill of a contact without a name",
+			formattedBody: `<a href="https://matrix.to/#/@messages_bob@example.com:example.com">Bobby</a>`,
+			want:          "bob@example.com",
+		},
+		{
+			name:          "ghost pill without a contact",
+			formattedBody: `<a href="https://matrix.to/#/@messages_+15555550199:example.com">Someone</a>`,
+			want:          "+15555550199",
+		},
+		{
+			name:          "pill of a Matrix user",
+			formattedBody: `<a href="https://matrix.to/#/@user:example.com">User</a>: hi`,
+			want:          "User: hi",
+		},
+		{
+			name:          "inline formatting",
+			formattedBody: `<strong>bold</strong>, <em>italic</em>, <del>struck</del> and <code>code</code>`,
+			want:          "bold, italic, struck and code",
+		},
+		{
+			name:          "unordered list",
+			formattedBody: `<ul><li>one</li><li>two</li></ul>`,
+			want:          "* one\n* two",
+		},
+		{
+			name:          "ordered list",
+			formattedBody: `<ol start="3"><li>three</li><li>four</li></ol>`,
+			want:          "3. three\n4. four",
+		},
+		{
+			name:          "quote",
+			formattedBody: `<blockquote>quoted<br>twice</blockquote>reply`,
+			want:          "> quoted\n> twice\nreply",
+		},
+		{
+			// Tabs in code are expanded to four spaces
+			name:          "code block",
+			formattedBody: "<pre><code class=\"language-go\">func main() {\n\treturn\n}\n</code></pre>",
+			want:          "func main() {\n    return\n}",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			content := &event.MessageEventContent{MsgType: event.MsgText, Body: test.body}
+			if test.formattedBody != "" {
+				content.Format = event.FormatHTML
+				content.FormattedBody = test.formattedBody
+			}
+			if got := client.convertMatrixText(context.Background(), content); got != test.want {
+				t.Errorf("convertMatrixText() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
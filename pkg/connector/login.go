@@ -12,9 +12,10 @@ import (
 )
 
 type MessagesLogin struct {
-	User      *bridgev2.User
-	Connector *MessagesConnector
-	UserID    string
+	User         *bridgev2.User
+	Connector    *MessagesConnector
+	ScriptRunner macos.ScriptRunner
+	UserID       string
 }
 
 var _ bridgev2.LoginProcessUserInput = (*MessagesLogin)(nil)
@@ -32,7 +33,9 @@ func (m *MessagesConnector) CreateLogin(ctx context.Context, user *bridgev2.User
 		return nil, fmt.Errorf("unknown login flow ID: %s", flowID)
 	}
 	return &MessagesLogin{
-		User: user,
+		User:         user,
+		Connector:    m,
+		ScriptRunner: m.ScriptRunner,
 	}, nil
 }
 
@@ -41,13 +44,12 @@ func (m *MessagesLogin) Cancel() {
 }
 
 func (m *MessagesLogin) Start(ctx context.Context) (*bridgev2.LoginStep, error) {
-	stdout, stderr, err := macos.RunOsascript(macos.GetOwnContactFirstPhone)
+	stdout, stderr, err := m.ScriptRunner.RunScript(macos.GetOwnContactFirstPhone)
 	if err != nil || len(stdout) == 0 || len(stderr) != 0 {
 		return nil, fmt.Errorf("error getting user contact phone number: %w\nstdout:\n%s\nstderr:\n%s", err, stdout, stderr)
 	}
-	// TODO: remove US assumption?
 	maybePhone := strings.TrimSuffix(stdout, "\n")
-	formattedPhoneNumber, err := macos.ParseFormatPhoneNumber(maybePhone, "US")
+	formattedPhoneNumber, err := macos.ParseFormatPhoneNumber(maybePhone, m.Connector.Config.DefaultRegion)
 	if err != nil {
 		return nil, fmt.Errorf("error parsing phone number (%s): %w", maybePhone, err)
 	}
@@ -78,9 +80,7 @@ func (m *MessagesLogin) SubmitUserInput(ctx context.Context, input map[string]st
 		},
 	}, &bridgev2.NewLoginParams{
 		LoadUserLogin: func(ctx context.Context, login *bridgev2.UserLogin) (err error) {
-			login.Client = &MessagesClient{
-				UserLogin: login,
-			}
+			login.Client = m.Connector.newClient(login)
 			return nil
 		},
 	})
@@ -0,0 +1,53 @@
+package connector
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/GroveJay/matrix-macOS-Messages-bridge/pkg/macos"
+	"github.com/GroveJay/matrix-macOS-Messages-bridge/pkg/macos/macostest"
+	"maunium.net/go/mautrix/bridgev2"
+)
+
+func TestMessagesLoginStart(t *testing.T) {
+	tests := []struct {
+		name             string
+		stdout           string
+		stderr           string
+		err              error
+		wantInstructions string
+		wantErr          string
+	}{
+		{"local number", "(555) 555-0123\n", "", nil, "+15555550123", ""},
+		{"international number", "+44 20 7946 0958\n", "", nil, "+442079460958", ""},
+		{"no contact card", "", "", nil, "", "error getting user contact phone number"},
+		{"script error", "", "execution error: Contacts got an error", errors.New("exit status 1"), "", "error getting user contact phone number: exit status 1"},
+		{"not a phone number", "me@example.com\n", "", nil, "", "error parsing phone number (me@example.com)"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			runner := macostest.NewFakeScriptRunner().On(macos.GetOwnContactFirstPhone, test.stdout, test.stderr, test.err)
+			login := &MessagesLogin{
+				Connector:    &MessagesConnector{Config: Config{DefaultRegion: "US"}},
+				ScriptRunner: runner,
+			}
+			step, err := login.Start(context.Background())
+			if test.wantErr != "" {
+				if err == nil || !strings.HasPrefix(err.Error(), test.wantErr) {
+					t.Errorf("err = %v, want %s", err, test.wantErr)
+				}
+				return
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if step.Type != bridgev2.LoginStepTypeUserInput || !strings.HasSuffix(step.Instructions, ": "+test.wantInstructions) {
+				t.Errorf("step %s asks %q, want the user to approve %s", step.Type, step.Instructions, test.wantInstructions)
+			}
+			if calls := runner.CallsFor(macos.GetOwnContactFirstPhone); len(calls) != 1 {
+				t.Errorf("looked up the contact %d times, want once", len(calls))
+			}
+		})
+	}
+}
@@ -0,0 +1,193 @@
+package connector
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/GroveJay/matrix-macOS-Messages-bridge/pkg/macos"
+	"maunium.net/go/mautrix/bridgev2"
+	"maunium.net/go/mautrix/bridgev2/database"
+	"maunium.net/go/mautrix/bridgev2/networkid"
+	"maunium.net/go/mautrix/bridgev2/simplevent"
+	"maunium.net/go/mautrix/event"
+)
+
+// HandleMatrixMessage implements bridgev2.NetworkAPI.
+func (m *MessagesClient) HandleMatrixMessage(ctx context.Context, msg *bridgev2.MatrixMessage) (message *bridgev2.MatrixMessageResponse, err error) {
+	_, chatGUID, err := macos.ParseMessagesPortalID(msg.Portal.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	switch msg.Content.MsgType {
+	case event.MsgText, event.MsgNotice:
+		return m.handleMatrixText(ctx, msg, chatGUID)
+	case event.MsgImage, event.MsgVideo, event.MsgAudio, event.MsgFile:
+		if !m.Config.Features.SendMedia {
+			return nil, fmt.Errorf("%w %s", bridgev2.ErrUnsupportedMessageType, msg.Content.MsgType)
+		}
+		return m.handleMatrixMedia(ctx, msg, chatGUID)
+	default:
+		return nil, fmt.Errorf("%w %s", bridgev2.ErrUnsupportedMessageType, msg.Content.MsgType)
+	}
+}
+
+func (m *MessagesClient) dryRunMatrixMessageResponse(msg *bridgev2.MatrixMessage, chatGUID string, description string) *bridgev2.MatrixMessageResponse {
+	m.UserLogin.Log.Info().Msgf("would send %s to %s", description, chatGUID)
+	sentPart := m.newSentMessagePart()
+	sentPart.ID = networkid.MessageID(msg.Event.ID)
+	return &bridgev2.MatrixMessageResponse{DB: sentPart}
+}
+
+// newSentMessagePart returns the database row of a message sent from Matrix. Those are always a single
+// text or file, which is the first part of the message like in ConvertMessageToParts.
+func (m *MessagesClient) newSentMessagePart() *database.Message {
+	return &database.Message{
+		PartID:   networkid.PartID("0"),
+		SenderID: networkid.UserID(m.UserLogin.ID),
+		Metadata: &MessageMetadata{IndexedPartID: true},
+	}
+}
+
+func (m *MessagesClient) handleMatrixText(ctx context.Context, msg *bridgev2.MatrixMessage, chatGUID string) (*bridgev2.MatrixMessageResponse, error) {
+	text := m.convertMatrixText(ctx, msg.Content)
+	if m.DryRun {
+		return m.dryRunMatrixMessageResponse(msg, chatGUID, fmt.Sprintf("message %q", text)), nil
+	}
+
+	send := &pendingSend{
+		TransactionID: networkid.TransactionID(msg.Event.ID),
+		ChatGUID:      chatGUID,
+		SentAt:        time.Now(),
+		Text:          text,
+	}
+	m.addPendingSend(msg, send)
+	if err := m.MacOSMessagesClient.SendMessage(chatGUID, text); err != nil {
+		m.removePendingSend(msg, send)
+		return nil, fmt.Errorf("sending message to %s: %w", chatGUID, err)
+	}
+	return &bridgev2.MatrixMessageResponse{Pending: true}, nil
+}
+
+// handleMatrixMedia downloads the media into the attachment spool and sends it as a file.
+// Captions are not sent, as Messages would store them as a separate message.
+func (m *MessagesClient) handleMatrixMedia(ctx context.Context, msg *bridgev2.MatrixMessage, chatGUID string) (*bridgev2.MatrixMessageResponse, error) {
+	fileName := msg.Content.FileName
+	if fileName == "" {
+		fileName = msg.Content.Body
+	}
+	if m.DryRun {
+		return m.dryRunMatrixMessageResponse(msg, chatGUID, fmt.Sprintf("file %q", fileName)), nil
+	}
+	if maxSize := m.AttachmentSpool.GetMaxSize(); maxSize > 0 && msg.Content.Info != nil && int64(msg.Content.Info.Size) > maxSize {
+		return nil, fmt.Errorf("%w: %d bytes (limit %d)", macos.ErrAttachmentTooLarge, msg.Content.Info.Size, maxSize)
+	}
+
+	var stagedPath string
+	err := m.UserLogin.Bridge.Bot.DownloadMediaToFile(ctx, msg.Content.URL, msg.Content.File, false, func(file *os.File) (err error) {
+		stagedPath, err = m.AttachmentSpool.Stage(file, fileName)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", bridgev2.ErrMediaDownloadFailed, err)
+	}
+
+	// The staged file has to stay around until Messages has copied it, which we only know once the echo arrives
+	send := &pendingSend{
+		TransactionID: networkid.TransactionID(msg.Event.ID),
+		ChatGUID:      chatGUID,
+		SentAt:        time.Now(),
+		TransferName:  filepath.Base(stagedPath),
+		StagedPath:    stagedPath,
+	}
+	m.addPendingSend(msg, send)
+	if err := m.MacOSMessagesClient.SendFile(chatGUID, stagedPath); err != nil {
+		m.removePendingSend(msg, send)
+		return nil, fmt.Errorf("sending file to %s: %w", chatGUID, err)
+	}
+	return &bridgev2.MatrixMessageResponse{Pending: true}, nil
+}
+
+// addPendingSend registers the send with both the bridge and our tracker before handing it to Messages,
+// so that an echo showing up in chat.db before the send command returns is still matched.
+func (m *MessagesClient) addPendingSend(msg *bridgev2.MatrixMessage, send *pendingSend) {
+	send.Message = msg
+	msg.AddPendingToSave(m.newSentMessagePart(), send.TransactionID, m.sentMessageEchoHandler(msg))
+	m.getPendingSends().Add(send)
+}
+
+func (m *MessagesClient) removePendingSend(msg *bridgev2.MatrixMessage, send *pendingSend) {
+	msg.RemovePending(send.TransactionID)
+	m.getPendingSends().Remove(send.TransactionID)
+	m.removeStagedAttachment(send)
+}
+
+// expirePendingSend gives up on a send that never showed up in chat.db and fails its Matrix event.
+func (m *MessagesClient) expirePendingSend(send *pendingSend) {
+	m.removeStagedAttachment(send)
+	if send.Message == nil {
+		return
+	}
+	send.Message.RemovePending(send.TransactionID)
+	m.UserLogin.Bridge.Matrix.SendMessageStatus(m.UserLogin.Log.WithContext(context.Background()), &bridgev2.MessageStatus{
+		Status:        event.MessageStatusFail,
+		ErrorReason:   event.MessageStatusNetworkError,
+		InternalError: ErrMessageNotSent,
+		Message:       fmt.Sprintf("Messages didn't send the message within %s", defaultPendingSendWindow),
+		SendNotice:    true,
+	}, bridgev2.StatusEventInfoFromEvent(send.Message.Event))
+}
+
+func (m *MessagesClient) getPendingSends() *pendingSendTracker {
+	m.pendingSendsLock.Lock()
+	defer m.pendingSendsLock.Unlock()
+	if m.pendingSends == nil {
+		m.pendingSends = newPendingSendTracker(defaultPendingSendWindow, m.expirePendingSend)
+	}
+	return m.pendingSends
+}
+
+// matchPendingSend returns the transaction ID of the Matrix event the message was sent from, if any.
+func (m *MessagesClient) matchPendingSend(message *macos.Message) networkid.TransactionID {
+	if !message.IsFromMe {
+		return ""
+	}
+	send := m.getPendingSends().Match(message)
+	if send == nil {
+		return ""
+	}
+	m.removeStagedAttachment(send)
+	return send.TransactionID
+}
+
+func (m *MessagesClient) removeStagedAttachment(send *pendingSend) {
+	if send.StagedPath == "" {
+		return
+	}
+	if err := m.AttachmentSpool.Remove(send.StagedPath); err != nil {
+		m.UserLogin.Log.Warn().Msgf("Failed to remove staged attachment %s: %v", send.StagedPath, err)
+	}
+}
+
+// sentMessageEchoHandler fills in what we only learn from chat.db before the bridge saves the sent message,
+// and starts following its send state. The bridge itself rewrites the message ID to the GUID of the echo.
+func (m *MessagesClient) sentMessageEchoHandler(msg *bridgev2.MatrixMessage) bridgev2.RemoteEchoHandler {
+	return func(remote bridgev2.RemoteMessage, dbMessage *database.Message) (bool, error) {
+		echo, ok := remote.(*simplevent.Message[macos.Message])
+		if !ok {
+			return true, nil
+		}
+		metadata, ok := dbMessage.Metadata.(*MessageMetadata)
+		if !ok || metadata == nil {
+			metadata = &MessageMetadata{}
+			dbMessage.Metadata = metadata
+		}
+		for _, attachment := range echo.Data.Attachments {
+			metadata.AttachmentGUIDs = append(metadata.AttachmentGUIDs, attachment.GUID)
+		}
+		return true, m.trackOutgoingMessage(msg, &echo.Data)
+	}
+}
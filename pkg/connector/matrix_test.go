@@ -0,0 +1,323 @@
+package connector
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/GroveJay/matrix-macOS-Messages-bridge/pkg/macos"
+	"github.com/GroveJay/matrix-macOS-Messages-bridge/pkg/macos/macostest"
+	"github.com/rs/zerolog"
+	"maunium.net/go/mautrix/bridgev2"
+	"maunium.net/go/mautrix/bridgev2/database"
+	"maunium.net/go/mautrix/bridgev2/networkid"
+	"maunium.net/go/mautrix/bridgev2/simplevent"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+func newTestMatrixMessage(portal *bridgev2.Portal, eventID id.EventID, content *event.MessageEventContent) *bridgev2.MatrixMessage {
+	return &bridgev2.MatrixMessage{MatrixEventBase: bridgev2.MatrixEventBase[*event.MessageEventContent]{
+		Event:   &event.Event{ID: eventID, RoomID: portal.MXID, Type: event.EventMessage},
+		Content: content,
+		Portal:  portal,
+	}}
+}
+
+// connectTestClient points the client's Messages client at the chat.db and returns the fake its scripts run against.
+func connectTestClient(t *testing.T, client *MessagesClient, chatDB *macostest.ChatDB) *macostest.FakeScriptRunner {
+	t.Helper()
+	log := zerolog.Nop()
+	var err error
+	client.MacOSMessagesClient, err = macos.GetMessagesClient("user", &log, chatDB.Paths(), client.ScriptRunner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client.ScriptRunner.(*macostest.FakeScriptRunner)
+}
+
+// connectTestContacts points the client's Contacts client at the AddressBook sources in the chat.db's home directory.
+func connectTestContacts(t *testing.T, client *MessagesClient, chatDB *macostest.ChatDB) {
+	t.Helper()
+	var err error
+	client.MacOSContactsClient, err = macos.GetContactsClient("user", chatDB.Paths(), "US", client.ScriptRunner)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHandleMatrixMessage(t *testing.T) {
+	tests := []struct {
+		name      string
+		content   *event.MessageEventContent
+		scriptErr error
+		wantErr   string
+		wantCalls []macostest.ScriptCall
+	}{
+		{
+			name:      "text",
+			content:   &event.MessageEventContent{MsgType: event.MsgText, Body: "hello"},
+			wantCalls: []macostest.ScriptCall{{Script: macos.SendMessage, Args: []string{testChatGUID, "hello"}}},
+		},
+		{
+			name: "formatted notice",
+			content: &event.MessageEventContent{
+				MsgType:       event.MsgNotice,
+				Body:          "**hello**",
+				Format:        event.FormatHTML,
+				FormattedBody: "<strong>hello</strong>",
+			},
+			wantCalls: []macostest.ScriptCall{{Script: macos.SendMessage, Args: []string{testChatGUID, "hello"}}},
+		},
+		{
+			name:      "send fails",
+			content:   &event.MessageEventContent{MsgType: event.MsgText, Body: "hello"},
+			scriptErr: errors.New("Messages got an error"),
+			wantErr:   "sending message to iMessage;-;+15555550123: Messages got an error",
+			wantCalls: []macostest.ScriptCall{{Script: macos.SendMessage, Args: []string{testChatGUID, "hello"}}},
+		},
+		{
+			name:    "unsupported type",
+			content: &event.MessageEventContent{MsgType: event.MsgLocation, Body: "here", GeoURI: "geo:0,0"},
+			wantErr: "unsupported message type m.location",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client, portal := newTestPortal(t)
+			runner := connectTestClient(t, client, macostest.NewChatDB(t))
+			if test.scriptErr != nil {
+				runner.On(macos.SendMessage, "", "", test.scriptErr)
+			}
+			msg := newTestMatrixMessage(portal, "$event", test.content)
+
+			resp, err := client.HandleMatrixMessage(context.Background(), msg)
+			if test.wantErr != "" {
+				if err == nil || !strings.HasPrefix(err.Error(), test.wantErr) {
+					t.Errorf("err = %v, want %s", err, test.wantErr)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			} else if !resp.Pending {
+				t.Errorf("response isn't pending")
+			}
+			if calls := runner.Calls(); !slices.EqualFunc(calls, test.wantCalls, func(a, b macostest.ScriptCall) bool {
+				return a.Script == b.Script && slices.Equal(a.Args, b.Args)
+			}) {
+				t.Errorf("script calls = %q, want %q", calls, test.wantCalls)
+			}
+
+			// The echo is only matched to the event if the send went through
+			echo := &macos.Message{IsFromMe: true, ChatGUID: testChatGUID, CreatedAt: time.Now(), Text: "hello"}
+			wantTransactionID := networkid.TransactionID("")
+			if test.wantErr == "" {
+				wantTransactionID = networkid.TransactionID(msg.Event.ID)
+			}
+			if transactionID := client.matchPendingSend(echo); transactionID != wantTransactionID {
+				t.Errorf("echo matched %q, want %q", transactionID, wantTransactionID)
+			}
+		})
+	}
+}
+
+func TestHandleMatrixMedia(t *testing.T) {
+	tests := []struct {
+		name         string
+		sendMedia    bool
+		missing      bool
+		scriptErr    error
+		wantErr      string
+		wantSendFile bool
+	}{
+		{name: "sent", sendMedia: true, wantSendFile: true},
+		{name: "send fails", sendMedia: true, scriptErr: errors.New("Messages got an error"), wantErr: "sending file to iMessage;-;+15555550123: Messages got an error", wantSendFile: true},
+		{name: "media not found", sendMedia: true, missing: true, wantErr: "failed to download media: "},
+		{name: "sending media disabled", wantErr: "unsupported message type m.image"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client, portal := newTestPortal(t)
+			runner := connectTestClient(t, client, macostest.NewChatDB(t))
+			if test.scriptErr != nil {
+				runner.On(macos.SendFile, "", "", test.scriptErr)
+			}
+			client.Config.Features.SendMedia = test.sendMedia
+			spoolDir := t.TempDir()
+			var err error
+			if client.AttachmentSpool, err = macos.NewAttachmentSpool(spoolDir, 0, 0); err != nil {
+				t.Fatal(err)
+			}
+			uri := id.ContentURIString("mxc://example.com/missing")
+			if !test.missing {
+				uri = client.UserLogin.Bridge.Matrix.(*testMatrixConnector).bot.PutMedia([]byte("jpeg"), "photo.jpg", "image/jpeg")
+			}
+			msg := newTestMatrixMessage(portal, "$event", &event.MessageEventContent{MsgType: event.MsgImage, Body: "photo.jpg", URL: uri})
+
+			_, err = client.HandleMatrixMessage(context.Background(), msg)
+			if test.wantErr != "" {
+				if err == nil || !strings.HasPrefix(err.Error(), test.wantErr) {
+					t.Errorf("err = %v, want %s", err, test.wantErr)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			calls := runner.CallsFor(macos.SendFile)
+			if !test.wantSendFile {
+				if len(calls) != 0 {
+					t.Errorf("script calls = %q, want none", calls)
+				}
+				return
+			}
+			if len(calls) != 1 || len(calls[0].Args) != 2 || calls[0].Args[0] != testChatGUID || filepath.Base(calls[0].Args[1]) != "photo.jpg" {
+				t.Fatalf("script calls = %q, want photo.jpg sent to %s", calls, testChatGUID)
+			}
+			stagedPath := calls[0].Args[1]
+			staged, err := os.ReadFile(stagedPath)
+			if test.wantErr != "" {
+				// A failed send doesn't leave anything in the spool
+				if !errors.Is(err, os.ErrNotExist) {
+					t.Errorf("staged file of the failed send still exists")
+				}
+				return
+			} else if err != nil || string(staged) != "jpeg" {
+				t.Errorf("staged file = %q (%v), want the downloaded media", staged, err)
+			}
+			echo := &macos.Message{IsFromMe: true, ChatGUID: testChatGUID, CreatedAt: time.Now(), Attachments: []*macos.Attachment{{FileName: "photo.jpg"}}}
+			if transactionID := client.matchPendingSend(echo); transactionID != networkid.TransactionID(msg.Event.ID) {
+				t.Errorf("echo matched %q, want %q", transactionID, msg.Event.ID)
+			}
+			if _, err := os.Stat(stagedPath); !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("staged file is kept after the echo was matched")
+			}
+		})
+	}
+}
+
+// readNewTestMessages returns the messages in the client's chat.db after the given row.
+func readNewTestMessages(t *testing.T, client *MessagesClient, afterRowID int64) []*macos.Message {
+	t.Helper()
+	messages, err := client.MacOSMessagesClient.GetMessagesAboveRowID(int(afterRowID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return messages
+}
+
+func TestMatrixSendRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	client, portal := newTestPortal(t)
+	chatDB := macostest.NewChatDB(t)
+	connectTestClient(t, client, chatDB)
+	handle := chatDB.Handle("+15555550123", "iMessage")
+	chat := chatDB.DirectChat(handle)
+	if chat.GUID != testChatGUID {
+		t.Fatalf("chat GUID = %s, want %s", chat.GUID, testChatGUID)
+	}
+
+	msg := newTestMatrixMessage(portal, "$sent", &event.MessageEventContent{MsgType: event.MsgText, Body: "hello"})
+	if _, err := client.HandleMatrixMessage(ctx, msg); err != nil {
+		t.Fatal(err)
+	}
+	sentAt := time.Now()
+	sent := chat.Message().FromMe().At(sentAt).Text("hello").AttributedBody(partsAttributedBody(t, "hello")).Insert()
+	client.HandleNormalMessage(readNewTestMessages(t, client, 0)[0])
+
+	// The echo is handled by the portal's event loop
+	messageID := networkid.MessageID(sent.GUID)
+	var parts []*database.Message
+	for deadline := time.Now().Add(5 * time.Second); len(parts) == 0 && time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		parts = getTestParts(t, portal, messageID)
+	}
+	if len(parts) != 1 || parts[0].MXID != msg.Event.ID {
+		t.Fatalf("saved parts = %v, want the sent event", parts)
+	}
+	if metadata, ok := parts[0].Metadata.(*MessageMetadata); parts[0].PartID != "0" || !ok || !metadata.IndexedPartID {
+		t.Errorf("saved part %q with metadata %+v, want the first part marked as indexed", parts[0].PartID, parts[0].Metadata)
+	}
+	findPart := func(partID networkid.PartID) *database.Message {
+		t.Helper()
+		part, err := portal.Bridge.DB.Message.GetPartByID(ctx, portal.Receiver, messageID, partID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return part
+	}
+
+	reply := chat.Message().From(handle).Text("hi").ReplyTo(sent, 0).Insert()
+	tapback := chat.Message().From(handle).Tapback(sent, 0, macos.TapbackLove).Insert()
+	messages := readNewTestMessages(t, client, sent.RowID)
+	if len(messages) != 2 || messages[0].GUID != reply.GUID || messages[1].GUID != tapback.GUID {
+		t.Fatalf("got %d messages, want the reply and the tapback", len(messages))
+	}
+
+	t.Run("reply", func(t *testing.T) {
+		converted, err := client.ConvertMessage(ctx, portal, macostest.NewFakeMatrixAPI(""), *messages[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if converted.ReplyTo == nil || converted.ReplyTo.MessageID != messageID || findPart(*converted.ReplyTo.PartID) == nil {
+			t.Errorf("reply to %+v, want the sent part", converted.ReplyTo)
+		}
+	})
+
+	t.Run("tapback", func(t *testing.T) {
+		evt := &partReactionSync{
+			ReactionSync: &simplevent.ReactionSync{TargetMessage: messageID},
+			TargetPart:   messages[1].Tapback.GetTargetPartID(),
+		}
+		evt.PreHandle(ctx, portal)
+		if findPart(evt.GetTargetMessagePart()) == nil {
+			t.Errorf("tapback on part %q, want the sent part", evt.GetTargetMessagePart())
+		}
+	})
+
+	t.Run("edit", func(t *testing.T) {
+		editedAt := sentAt.Add(time.Minute)
+		chatDB.SetEdited(sent, editedAt, "hello, edited", partsAttributedBody(t, "hello, edited"),
+			macostest.NewSummaryInfo(1).Edit(0, sentAt, "hello").Edit(0, editedAt, "hello, edited").Bytes())
+		edited := readNewTestMessages(t, client, 0)[0]
+		existing := getTestParts(t, portal, messageID)
+		convertedEdit, err := client.ConvertEditMessage(ctx, portal, macostest.NewFakeMatrixAPI(""), existing, *edited)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if convertedEdit.AddedParts != nil || len(convertedEdit.DeletedParts) != 0 {
+			t.Errorf("edit added %v and deleted %v, want the existing part to be kept", convertedEdit.AddedParts, convertedEdit.DeletedParts)
+		}
+		partEdits := edited.GetPartEdits()
+		if len(partEdits) != 1 {
+			t.Fatalf("got %d part edits, want 1", len(partEdits))
+		}
+		partEdit, err := client.convertPartEditFunc(partEdits[0])(ctx, portal, macostest.NewFakeMatrixAPI(""), existing, *edited)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(partEdit.ModifiedParts) != 1 || partEdit.ModifiedParts[0].Part.MXID != msg.Event.ID {
+			t.Errorf("edit modified %v, want the sent part", partEdit.ModifiedParts)
+		}
+	})
+}
+
+func TestSentMessageEchoHandler(t *testing.T) {
+	client, portal := newTestPortal(t)
+	msg := newTestMatrixMessage(portal, "$sent", &event.MessageEventContent{MsgType: event.MsgFile, Body: "file.pdf"})
+	dbMessage := client.newSentMessagePart()
+	echo := &simplevent.Message[macos.Message]{Data: macos.Message{
+		GUID:        "message",
+		ChatGUID:    testChatGUID,
+		Attachments: []*macos.Attachment{{GUID: "attachment", FileName: "file.pdf"}},
+	}}
+	if save, err := client.sentMessageEchoHandler(msg)(echo, dbMessage); !save || err != nil {
+		t.Fatalf("echo handler returned %t, %v, want the message to be saved", save, err)
+	}
+	metadata := dbMessage.Metadata.(*MessageMetadata)
+	if !metadata.IndexedPartID || !slices.Equal(metadata.AttachmentGUIDs, []string{"attachment"}) {
+		t.Errorf("metadata = %+v, want the attachment GUID added to the sent part's metadata", metadata)
+	}
+}
@@ -0,0 +1,144 @@
+package connector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/GroveJay/matrix-macOS-Messages-bridge/pkg/macos"
+	"maunium.net/go/mautrix/bridgev2"
+	"maunium.net/go/mautrix/bridgev2/networkid"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+const (
+	// How long a sent message can go undelivered before we tell Matrix about it
+	defaultDeliveryTimeout = 10 * time.Minute
+	// How often sends are checked for timeouts, which are due even when chat.db doesn't change
+	outgoingCheckInterval = 30 * time.Second
+)
+
+var ErrMessageNotDelivered = errors.New("message was not delivered")
+
+// outgoingMessage is a message sent from Matrix whose send state we're still following in chat.db.
+type outgoingMessage struct {
+	GUID        string
+	Event       *bridgev2.MessageStatusEventInfo
+	DeliveredTo []id.UserID
+	SentAt      time.Time
+	Sent        bool
+	// Whether being sent is as far as the message gets, as Messages never sets is_delivered for it
+	SettledWhenSent bool
+}
+
+// trackOutgoingMessage reports the current state of an echoed Matrix message and follows it until it's delivered or fails.
+// The bridge's own success status is replaced by ours, so it returns bridgev2.ErrNoStatus unless delivery status is disabled.
+func (m *MessagesClient) trackOutgoingMessage(msg *bridgev2.MatrixMessage, echo *macos.Message) error {
+	if !m.Config.Features.DeliveryStatus {
+		return nil
+	}
+	outgoing := &outgoingMessage{
+		GUID:   echo.GUID,
+		Event:  bridgev2.StatusEventInfoFromEvent(msg.Event),
+		SentAt: echo.CreatedAt,
+	}
+	// Only one-to-one iMessage chats report delivery, and tell us who a message was delivered to
+	if chatID := macos.ParseIdentifier(echo.ChatGUID); chatID.IsGroup || chatID.Service != "iMessage" {
+		outgoing.SettledWhenSent = true
+	} else {
+		ghostMXID := m.UserLogin.Bridge.Matrix.GhostIntent(networkid.UserID(chatID.LocalID)).GetMXID()
+		outgoing.DeliveredTo = []id.UserID{ghostMXID}
+	}
+
+	if !m.updateOutgoingMessage(m.UserLogin.Log.WithContext(context.Background()), outgoing, echo.GetOutgoingStatus()) {
+		m.outgoingMessagesLock.Lock()
+		if m.outgoingMessages == nil {
+			m.outgoingMessages = make(map[string]*outgoingMessage)
+		}
+		m.outgoingMessages[outgoing.GUID] = outgoing
+		m.outgoingMessagesLock.Unlock()
+	}
+	return bridgev2.ErrNoStatus
+}
+
+// checkOutgoingLoop fails the sends that never showed up in chat.db or were never delivered,
+// until OutgoingCheckStopChannel is signalled.
+func (m *MessagesClient) checkOutgoingLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.OutgoingCheckStopChannel:
+			m.UserLogin.Log.Debug().Msg("Stopping outgoing message check loop")
+			return
+		case <-ticker.C:
+			m.getPendingSends().Expire()
+			m.checkOutgoingMessages(m.UserLogin.Log.WithContext(context.Background()))
+		}
+	}
+}
+
+// checkOutgoingMessages looks up the state of every tracked message, as is_sent, is_delivered and error
+// change without bumping any of the dates the watcher queries by.
+func (m *MessagesClient) checkOutgoingMessages(ctx context.Context) {
+	m.outgoingMessagesLock.Lock()
+	defer m.outgoingMessagesLock.Unlock()
+	for guid, outgoing := range m.outgoingMessages {
+		outgoingStatus, err := m.MacOSMessagesClient.GetOutgoingStatus(guid)
+		if err != nil {
+			m.UserLogin.Log.Warn().Msgf("Failed to check status of outgoing message %s: %v", guid, err)
+			continue
+		}
+		if m.updateOutgoingMessage(ctx, outgoing, outgoingStatus) {
+			delete(m.outgoingMessages, guid)
+		} else if time.Since(outgoing.SentAt) > defaultDeliveryTimeout {
+			m.sendOutgoingStatus(ctx, outgoing, &bridgev2.MessageStatus{
+				Status:        event.MessageStatusFail,
+				ErrorReason:   event.MessageStatusNetworkError,
+				InternalError: ErrMessageNotDelivered,
+				Message:       fmt.Sprintf("Not delivered after %s", defaultDeliveryTimeout),
+				SendNotice:    true,
+			})
+			delete(m.outgoingMessages, guid)
+		}
+	}
+}
+
+// updateOutgoingMessage sends the Matrix status for a change in send state and reports whether the message is settled.
+func (m *MessagesClient) updateOutgoingMessage(ctx context.Context, outgoing *outgoingMessage, outgoingStatus *macos.OutgoingStatus) bool {
+	switch {
+	case outgoingStatus.Error != 0:
+		m.sendOutgoingStatus(ctx, outgoing, &bridgev2.MessageStatus{
+			Status:        event.MessageStatusFail,
+			ErrorReason:   event.MessageStatusNetworkError,
+			InternalError: fmt.Errorf("messages error %d", outgoingStatus.Error),
+			Message:       "Messages failed to send the message",
+			IsCertain:     true,
+			SendNotice:    true,
+		})
+		return true
+	case outgoingStatus.IsDelivered:
+		m.sendOutgoingStatus(ctx, outgoing, &bridgev2.MessageStatus{
+			Status:      event.MessageStatusSuccess,
+			DeliveredTo: outgoing.DeliveredTo,
+		})
+		return true
+	case outgoingStatus.IsSent && !outgoing.Sent:
+		outgoing.Sent = true
+		m.sendOutgoingStatus(ctx, outgoing, &bridgev2.MessageStatus{
+			Status: event.MessageStatusSuccess,
+		})
+		return outgoing.SettledWhenSent
+	}
+	return false
+}
+
+func (m *MessagesClient) sendOutgoingStatus(ctx context.Context, outgoing *outgoingMessage, messageStatus *bridgev2.MessageStatus) {
+	if m.DryRun {
+		m.UserLogin.Log.Info().Msgf("would send status %s for %s", messageStatus.Status, outgoing.GUID)
+		return
+	}
+	m.UserLogin.Bridge.Matrix.SendMessageStatus(ctx, messageStatus, outgoing.Event)
+}
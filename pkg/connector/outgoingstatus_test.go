@@ -0,0 +1,112 @@
+package connector
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/GroveJay/matrix-macOS-Messages-bridge/pkg/macos/macostest"
+	"maunium.net/go/mautrix/bridgev2"
+	"maunium.net/go/mautrix/bridgev2/networkid"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+func TestCheckOutgoingMessages(t *testing.T) {
+	overdue := time.Now().Add(-defaultDeliveryTimeout - time.Minute)
+	tests := []struct {
+		name        string
+		chat        func(chatDB *macostest.ChatDB) *macostest.Chat
+		sentAt      time.Time
+		delivered   bool
+		wantStatus  event.MessageStatus
+		wantErr     error
+		wantTracked bool
+	}{
+		{
+			name: "iMessage delivered",
+			chat: func(chatDB *macostest.ChatDB) *macostest.Chat {
+				return chatDB.DirectChat(chatDB.Handle("+15555550123", "iMessage"))
+			},
+			sentAt:     overdue,
+			delivered:  true,
+			wantStatus: event.MessageStatusSuccess,
+		},
+		{
+			name: "iMessage waiting for delivery",
+			chat: func(chatDB *macostest.ChatDB) *macostest.Chat {
+				return chatDB.DirectChat(chatDB.Handle("+15555550123", "iMessage"))
+			},
+			sentAt:      time.Now(),
+			wantStatus:  event.MessageStatusSuccess,
+			wantTracked: true,
+		},
+		{
+			name: "iMessage not delivered",
+			chat: func(chatDB *macostest.ChatDB) *macostest.Chat {
+				return chatDB.DirectChat(chatDB.Handle("+15555550123", "iMessage"))
+			},
+			sentAt:     overdue,
+			wantStatus: event.MessageStatusFail,
+			wantErr:    ErrMessageNotDelivered,
+		},
+		{
+			// SMS never reports delivery, so being sent is final
+			name: "SMS sent",
+			chat: func(chatDB *macostest.ChatDB) *macostest.Chat {
+				return chatDB.DirectChat(chatDB.Handle("+15555550123", "SMS"))
+			},
+			sentAt:     overdue,
+			wantStatus: event.MessageStatusSuccess,
+		},
+		{
+			// Neither do group chats, as there's no single recipient to deliver to
+			name: "group sent",
+			chat: func(chatDB *macostest.ChatDB) *macostest.Chat {
+				return chatDB.GroupChat("Friends", chatDB.Handle("+15555550123", "iMessage"), chatDB.Handle("bob@example.com", "iMessage"))
+			},
+			sentAt:     overdue,
+			wantStatus: event.MessageStatusSuccess,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client, portal := newTestPortal(t)
+			client.Config.Features.DeliveryStatus = true
+			chatDB := macostest.NewChatDB(t)
+			connectTestClient(t, client, chatDB)
+			builder := test.chat(chatDB).Message().FromMe().Text("hello").At(test.sentAt)
+			if test.delivered {
+				builder = builder.Delivered(test.sentAt)
+			}
+			builder.Insert()
+			echoes := readNewTestMessages(t, client, 0)
+			if len(echoes) != 1 {
+				t.Fatalf("read %d messages, want 1", len(echoes))
+			}
+			echo := echoes[0]
+
+			msg := newTestMatrixMessage(portal, "$sent", &event.MessageEventContent{MsgType: event.MsgText, Body: "hello"})
+			if err := client.trackOutgoingMessage(msg, echo); !errors.Is(err, bridgev2.ErrNoStatus) {
+				t.Fatalf("trackOutgoingMessage() = %v, want %v", err, bridgev2.ErrNoStatus)
+			}
+			client.checkOutgoingMessages(context.Background())
+
+			status := client.UserLogin.Bridge.Matrix.(*testMatrixConnector).status("$sent")
+			if status == nil || status.Status != test.wantStatus || !errors.Is(status.InternalError, test.wantErr) {
+				t.Fatalf("status = %+v, want %s with error %v", status, test.wantStatus, test.wantErr)
+			}
+			if test.delivered {
+				ghostMXID := client.UserLogin.Bridge.Matrix.GhostIntent(networkid.UserID("+15555550123")).GetMXID()
+				if !slices.Equal(status.DeliveredTo, []id.UserID{ghostMXID}) {
+					t.Errorf("delivered to %v, want %s", status.DeliveredTo, ghostMXID)
+				}
+			}
+			if _, tracked := client.outgoingMessages[echo.GUID]; tracked != test.wantTracked {
+				t.Errorf("still tracked = %t, want %t", tracked, test.wantTracked)
+			}
+		})
+	}
+}
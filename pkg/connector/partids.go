@@ -0,0 +1,82 @@
+package connector
+
+import (
+	"context"
+	"slices"
+	"strconv"
+
+	"github.com/GroveJay/matrix-macOS-Messages-bridge/pkg/macos"
+	"maunium.net/go/mautrix/bridgev2"
+	"maunium.net/go/mautrix/bridgev2/database"
+	"maunium.net/go/mautrix/bridgev2/networkid"
+)
+
+// markIndexedPartIDs records in the metadata of new parts that their IDs are the indexes of the parts in the message.
+func markIndexedPartIDs(parts []*bridgev2.ConvertedMessagePart) {
+	for _, part := range parts {
+		metadata, ok := part.DBMetadata.(*MessageMetadata)
+		if !ok || metadata == nil {
+			metadata = &MessageMetadata{}
+			part.DBMetadata = metadata
+		}
+		metadata.IndexedPartID = true
+	}
+}
+
+// rekeyLegacyParts renames the parts of a message that were bridged when parts were numbered by their position
+// among the bridged parts, so that they can be found by the index of the part in the message like newer parts.
+// The two only differ when a part of the message wasn't bridged, e.g. one that had already been unsent.
+// Renamed parts are marked like new ones, so a message is never renamed twice.
+func (m *MessagesClient) rekeyLegacyParts(ctx context.Context, portal *bridgev2.Portal, data macos.Message) {
+	existing, err := portal.Bridge.DB.Message.GetAllPartsByID(ctx, portal.Receiver, networkid.MessageID(data.GUID))
+	if err != nil {
+		m.UserLogin.Log.Warn().Msgf("Failed to get parts of message %s to rename: %v", data.GUID, err)
+		return
+	}
+	existingByID := make(map[networkid.PartID]*database.Message, len(existing))
+	for _, existingPart := range existing {
+		if metadata, ok := existingPart.Metadata.(*MessageMetadata); ok && metadata != nil && metadata.IndexedPartID {
+			return
+		}
+		existingByID[existingPart.PartID] = existingPart
+	}
+	partIndexes := data.PartIndexes()
+	renamed := []*database.Message{}
+	// A part's index is never lower than its position, so renaming from the last part on never takes an ID that's still in use
+	for position := len(partIndexes) - 1; position >= 0; position-- {
+		existingPart, ok := existingByID[networkid.PartID(strconv.Itoa(position))]
+		if !ok || partIndexes[position] == position {
+			continue
+		}
+		existingPart.PartID = networkid.PartID(strconv.Itoa(partIndexes[position]))
+		renamed = append(renamed, existingPart)
+	}
+	if len(renamed) == 0 {
+		return
+	}
+	// Renamed parts are saved in the same order, and the others only need to be marked
+	for _, existingPart := range existing {
+		if !slices.Contains(renamed, existingPart) {
+			renamed = append(renamed, existingPart)
+		}
+	}
+	for _, existingPart := range renamed {
+		metadata, ok := existingPart.Metadata.(*MessageMetadata)
+		if !ok || metadata == nil {
+			metadata = &MessageMetadata{}
+			existingPart.Metadata = metadata
+		}
+		metadata.IndexedPartID = true
+		if err := portal.Bridge.DB.Message.Update(ctx, existingPart); err != nil {
+			m.UserLogin.Log.Warn().Msgf("Failed to rename part %s of message %s: %v", existingPart.PartID, existingPart.ID, err)
+		}
+	}
+}
+
+// rekeyLegacyPartsFunc returns a pre-handler for edits of the message, which renames its legacy parts before the edit
+// is converted. Converting has to stay free of side effects, as the bridge can still drop the edit afterwards.
+func (m *MessagesClient) rekeyLegacyPartsFunc(message *macos.Message) func(context.Context, *bridgev2.Portal) {
+	return func(ctx context.Context, portal *bridgev2.Portal) {
+		m.rekeyLegacyParts(ctx, portal, *message)
+	}
+}
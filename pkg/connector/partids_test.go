@@ -0,0 +1,243 @@
+package connector
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/GroveJay/matrix-macOS-Messages-bridge/pkg/macos"
+	"github.com/GroveJay/matrix-macOS-Messages-bridge/pkg/macos/macostest"
+	"github.com/rs/zerolog"
+	"go.mau.fi/util/dbutil"
+	"maunium.net/go/mautrix/bridgev2"
+	"maunium.net/go/mautrix/bridgev2/database"
+	"maunium.net/go/mautrix/bridgev2/networkid"
+	"maunium.net/go/mautrix/id"
+)
+
+var testPortalKey = networkid.PortalKey{ID: macos.MakeMessagesPortalID("login", testChatGUID), Receiver: "login"}
+
+const testChatGUID = "iMessage;-;+15555550123"
+
+// testMatrixConnector is the Matrix side of the test bridge. The bot and ghosts are fake Matrix APIs and message
+// statuses are recorded, anything else the bridge calls panics.
+type testMatrixConnector struct {
+	bridgev2.MatrixConnector
+	bot *macostest.FakeMatrixAPI
+
+	lock     sync.Mutex
+	statuses map[id.EventID]*bridgev2.MessageStatus
+}
+
+func (t *testMatrixConnector) Init(*bridgev2.Bridge) {}
+
+func (t *testMatrixConnector) BotIntent() bridgev2.MatrixAPI {
+	return t.bot
+}
+
+func (t *testMatrixConnector) GhostIntent(userID networkid.UserID) bridgev2.MatrixAPI {
+	return macostest.NewFakeMatrixAPI(id.UserID(fmt.Sprintf("@messages_%s:example.com", userID)))
+}
+
+func (t *testMatrixConnector) ParseGhostMXID(userID id.UserID) (networkid.UserID, bool) {
+	localpart, ok := strings.CutPrefix(string(userID), "@messages_")
+	if !ok {
+		return "", false
+	}
+	localpart, ok = strings.CutSuffix(localpart, ":example.com")
+	return networkid.UserID(localpart), ok
+}
+
+// NewUserIntent fails, so the bridge falls back to the bot instead of double puppeting the user.
+func (t *testMatrixConnector) NewUserIntent(ctx context.Context, userID id.UserID, accessToken string) (bridgev2.MatrixAPI, string, error) {
+	return nil, "", errors.New("double puppeting isn't supported in tests")
+}
+
+func (t *testMatrixConnector) SendMessageStatus(ctx context.Context, status *bridgev2.MessageStatus, evt *bridgev2.MessageStatusEventInfo) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.statuses[evt.SourceEventID] = status
+}
+
+func (t *testMatrixConnector) status(eventID id.EventID) *bridgev2.MessageStatus {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.statuses[eventID]
+}
+
+// newTestPortal returns a portal backed by an empty bridge database, along with a client logged in to it.
+// The bridge's Matrix connector is a testMatrixConnector.
+func newTestPortal(t *testing.T) (*MessagesClient, *bridgev2.Portal) {
+	t.Helper()
+	ctx := context.Background()
+	rawDB, err := sql.Open("sqlite3", fmt.Sprintf("file:%s", filepath.Join(t.TempDir(), "bridge.db")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { rawDB.Close() })
+	db, err := dbutil.NewWithDB(rawDB, "sqlite3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	matrix := &testMatrixConnector{
+		bot:      macostest.NewFakeMatrixAPI(""),
+		statuses: make(map[id.EventID]*bridgev2.MessageStatus),
+	}
+	connector := &MessagesConnector{ScriptRunner: macostest.NewFakeScriptRunner()}
+	bridge := bridgev2.NewBridge("bridge", db, zerolog.Nop(), nil, matrix, connector, func(*bridgev2.Bridge) bridgev2.CommandProcessor {
+		return nil
+	})
+	// Set by Start, which would also connect the logins
+	bridge.BackgroundCtx = ctx
+	if err := bridge.DB.Upgrade(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := bridge.DB.Portal.Insert(ctx, &database.Portal{PortalKey: testPortalKey, MXID: "!room:example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	portal, err := bridge.GetPortalByKey(ctx, testPortalKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Loading the login through the bridge makes it usable as the source of remote events
+	user, err := bridge.GetUserByMXID(ctx, "@user:example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = bridge.DB.UserLogin.Insert(ctx, &database.UserLogin{
+		ID:       testPortalKey.Receiver,
+		UserMXID: user.MXID,
+		Metadata: &UserLoginMetadata{UserID: string(testPortalKey.Receiver)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	login, err := bridge.GetExistingUserLoginByID(ctx, testPortalKey.Receiver)
+	if err != nil || login == nil {
+		t.Fatalf("loading login: %v", err)
+	}
+	client := login.Client.(*MessagesClient)
+	return client, portal
+}
+
+// insertTestParts saves parts of a message with the given part IDs and metadata.
+func insertTestParts(t *testing.T, portal *bridgev2.Portal, messageID networkid.MessageID, metadata func() *MessageMetadata, partIDs ...networkid.PartID) []*database.Message {
+	t.Helper()
+	for _, partID := range partIDs {
+		err := portal.Bridge.DB.Message.Insert(context.Background(), &database.Message{
+			ID:        messageID,
+			PartID:    partID,
+			MXID:      id.EventID(fmt.Sprintf("$%s-%s", messageID, partID)),
+			Room:      portal.PortalKey,
+			SenderID:  "sender",
+			Timestamp: time.Unix(1700000000, 0),
+			Metadata:  metadata(),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return getTestParts(t, portal, messageID)
+}
+
+func getTestParts(t *testing.T, portal *bridgev2.Portal, messageID networkid.MessageID) []*database.Message {
+	t.Helper()
+	parts, err := portal.Bridge.DB.Message.GetAllPartsByID(context.Background(), portal.Receiver, messageID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parts
+}
+
+func TestRekeyLegacyParts(t *testing.T) {
+	components := []macos.CombinedComponent{
+		macos.CombinedComponentRetraction{},
+		macos.CombinedComponentAttachment{},
+		macos.CombinedComponentText{},
+	}
+	// Without a summary the retraction has no part, so the other two were bridged as parts 0 and 1
+	withoutSummary := macos.Message{AttributedBodyText: "￼hi", CombinedComponents: components}
+	withSummary := macos.Message{
+		AttributedBodyText: "￼hi",
+		CombinedComponents: components,
+		EditedMessageParts: []*macos.EditedMessagePart{
+			{Status: macos.EditedMessageStatusUnsent},
+			{Status: macos.EditedMessageStatusOriginal},
+			{Status: macos.EditedMessageStatusOriginal},
+		},
+	}
+	legacyMetadata := func() *MessageMetadata { return &MessageMetadata{} }
+	tests := []struct {
+		name        string
+		metadata    func() *MessageMetadata
+		data        macos.Message
+		partIDs     []networkid.PartID
+		wantPartIDs map[id.EventID]networkid.PartID
+	}{
+		{"legacy parts are renamed", legacyMetadata, withoutSummary,
+			[]networkid.PartID{"0", "1"}, map[id.EventID]networkid.PartID{"$msg-0": "1", "$msg-1": "2"}},
+		{"marked parts are left alone", func() *MessageMetadata { return &MessageMetadata{IndexedPartID: true} }, withoutSummary,
+			[]networkid.PartID{"0", "1"}, map[id.EventID]networkid.PartID{"$msg-0": "0", "$msg-1": "1"}},
+		{"parts with the same IDs either way are left alone", legacyMetadata, withSummary,
+			[]networkid.PartID{"0", "1", "2"}, map[id.EventID]networkid.PartID{"$msg-0": "0", "$msg-1": "1", "$msg-2": "2"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client, portal := newTestPortal(t)
+			insertTestParts(t, portal, "msg", test.metadata, test.partIDs...)
+			data := test.data
+			data.GUID = "msg"
+			client.rekeyLegacyParts(context.Background(), portal, data)
+			// Parts that were renamed once must not move again
+			client.rekeyLegacyParts(context.Background(), portal, data)
+			for _, part := range getTestParts(t, portal, "msg") {
+				if want := test.wantPartIDs[part.MXID]; part.PartID != want {
+					t.Errorf("part %s has ID %q, want %q", part.MXID, part.PartID, want)
+				}
+			}
+		})
+	}
+}
+
+func TestHandleEditRekeysLegacyParts(t *testing.T) {
+	ctx := context.Background()
+	client, portal := newTestPortal(t)
+	// Without a summary the retraction has no part, so the text was bridged as the legacy part 0
+	message := &macos.Message{
+		GUID:               "msg",
+		ChatGUID:           testChatGUID,
+		IsFromMe:           true,
+		AttributedBodyText: "hi",
+		CombinedComponents: []macos.CombinedComponent{
+			macos.CombinedComponentRetraction{},
+			macos.CombinedComponentText{TextRangeEffects: []macos.TextRangeEffect{{Start: 0, End: 2, TextEffect: macos.TextEffectDefault{}}}},
+		},
+	}
+	existing := insertTestParts(t, portal, "msg", func() *MessageMetadata { return &MessageMetadata{} }, "0")
+
+	// Converting the edit doesn't rename anything, as the bridge may still drop it
+	if _, err := client.ConvertEditMessage(ctx, portal, macostest.NewFakeMatrixAPI(""), existing, *message); err != nil {
+		t.Fatal(err)
+	}
+	if parts := getTestParts(t, portal, "msg"); len(parts) != 1 || parts[0].PartID != "0" {
+		t.Fatalf("parts after converting = %v, want the legacy part 0 untouched", parts)
+	}
+
+	// The edit is handled by the portal's event loop, which renames the parts before converting it
+	client.HandleEdit(message)
+	var parts []*database.Message
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if parts = getTestParts(t, portal, "msg"); len(parts) == 1 && parts[0].PartID == "1" {
+			break
+		}
+	}
+	if len(parts) != 1 || parts[0].PartID != "1" || parts[0].MXID != "$msg-0" {
+		t.Fatalf("parts after the edit = %v, want $msg-0 renamed to part 1", parts)
+	}
+}
@@ -0,0 +1,125 @@
+package connector
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/GroveJay/matrix-macOS-Messages-bridge/pkg/macos"
+	"maunium.net/go/mautrix/bridgev2"
+	"maunium.net/go/mautrix/bridgev2/networkid"
+)
+
+const (
+	// How long after sending a Matrix event its row in chat.db can still be matched to it
+	defaultPendingSendWindow = 2 * time.Minute
+	// chat.db dates can be slightly behind the time we recorded before sending
+	pendingSendClockSlack = 2 * time.Second
+)
+
+var ErrMessageNotSent = errors.New("message did not show up in chat.db")
+
+// pendingSend is a Matrix event that was handed to Messages but hasn't shown up in chat.db yet.
+type pendingSend struct {
+	// The Matrix event the send came from, which is failed if the send expires
+	Message       *bridgev2.MatrixMessage
+	TransactionID networkid.TransactionID
+	ChatGUID      string
+	SentAt        time.Time
+
+	// Exactly one of these fingerprints is set, depending on what was sent
+	Text         string
+	TransferName string
+
+	// The spooled copy of a sent attachment, removed once the send is matched or expires
+	StagedPath string
+}
+
+func (p *pendingSend) matches(message *macos.Message, window time.Duration) bool {
+	if message.ChatGUID != p.ChatGUID ||
+		message.CreatedAt.Before(p.SentAt.Add(-pendingSendClockSlack)) ||
+		message.CreatedAt.After(p.SentAt.Add(window)) {
+		return false
+	}
+	if p.TransferName != "" {
+		for _, attachment := range message.Attachments {
+			if attachment.FileName == p.TransferName {
+				return true
+			}
+		}
+		return false
+	}
+	text := strings.TrimSpace(p.Text)
+	return strings.TrimSpace(message.Text) == text || strings.TrimSpace(message.AttributedBodyText) == text
+}
+
+// pendingSendTracker matches is_from_me rows from chat.db to the Matrix events that produced them,
+// as the AppleScript send commands don't tell us the GUID of the message they created.
+type pendingSendTracker struct {
+	lock    sync.Mutex
+	pending []*pendingSend
+	window  time.Duration
+	// Called with sends that expired without a match
+	onExpire func(*pendingSend)
+}
+
+func newPendingSendTracker(window time.Duration, onExpire func(*pendingSend)) *pendingSendTracker {
+	return &pendingSendTracker{
+		window:   window,
+		onExpire: onExpire,
+	}
+}
+
+func (t *pendingSendTracker) Add(send *pendingSend) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.pending = append(t.pending, send)
+}
+
+func (t *pendingSendTracker) Remove(transactionID networkid.TransactionID) *pendingSend {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	for index, send := range t.pending {
+		if send.TransactionID == transactionID {
+			t.pending = append(t.pending[:index], t.pending[index+1:]...)
+			return send
+		}
+	}
+	return nil
+}
+
+// Match finds and removes the oldest pending send that the message is the echo of.
+func (t *pendingSendTracker) Match(message *macos.Message) *pendingSend {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	for index, send := range t.pending {
+		if send.matches(message, t.window) {
+			t.pending = append(t.pending[:index], t.pending[index+1:]...)
+			return send
+		}
+	}
+	return nil
+}
+
+// Expire removes the sends that are too old to be matched any more and hands them to onExpire.
+func (t *pendingSendTracker) Expire() {
+	t.lock.Lock()
+	var expired []*pendingSend
+	remaining := t.pending[:0]
+	for _, send := range t.pending {
+		if time.Since(send.SentAt) > t.window {
+			expired = append(expired, send)
+		} else {
+			remaining = append(remaining, send)
+		}
+	}
+	t.pending = remaining
+	t.lock.Unlock()
+
+	if t.onExpire != nil {
+		for _, send := range expired {
+			t.onExpire(send)
+		}
+	}
+}
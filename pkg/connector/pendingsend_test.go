@@ -0,0 +1,96 @@
+package connector
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/GroveJay/matrix-macOS-Messages-bridge/pkg/macos"
+	"maunium.net/go/mautrix/bridgev2"
+	"maunium.net/go/mautrix/bridgev2/networkid"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+func TestPendingSendTrackerMatch(t *testing.T) {
+	sentAt := time.Now()
+	tests := []struct {
+		name    string
+		message *macos.Message
+		want    string
+	}{
+		{"text", &macos.Message{ChatGUID: "chat", CreatedAt: sentAt, Text: "hello "}, "$text"},
+		{"attributed body text", &macos.Message{ChatGUID: "chat", CreatedAt: sentAt, AttributedBodyText: "hello"}, "$text"},
+		{"attachment", &macos.Message{ChatGUID: "chat", CreatedAt: sentAt, Attachments: []*macos.Attachment{{FileName: "photo.jpg"}}}, "$file"},
+		{"other chat", &macos.Message{ChatGUID: "other", CreatedAt: sentAt, Text: "hello"}, ""},
+		{"before the send", &macos.Message{ChatGUID: "chat", CreatedAt: sentAt.Add(-time.Minute), Text: "hello"}, ""},
+		{"after the window", &macos.Message{ChatGUID: "chat", CreatedAt: sentAt.Add(3 * time.Minute), Text: "hello"}, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tracker := newPendingSendTracker(defaultPendingSendWindow, nil)
+			tracker.Add(&pendingSend{TransactionID: "$text", ChatGUID: "chat", SentAt: sentAt, Text: "hello"})
+			tracker.Add(&pendingSend{TransactionID: "$file", ChatGUID: "chat", SentAt: sentAt, TransferName: "photo.jpg"})
+			var got string
+			if send := tracker.Match(test.message); send != nil {
+				got = string(send.TransactionID)
+			}
+			if got != test.want {
+				t.Errorf("matched %q, want %q", got, test.want)
+			}
+			// A matched send is only matched once
+			if send := tracker.Match(test.message); send != nil {
+				t.Errorf("matched %q a second time", send.TransactionID)
+			}
+		})
+	}
+}
+
+func TestCheckOutgoingLoopExpiresPendingSends(t *testing.T) {
+	client, portal := newTestPortal(t)
+	for _, send := range []struct {
+		eventID id.EventID
+		sentAt  time.Time
+	}{
+		{"$expired", time.Now().Add(-defaultPendingSendWindow - time.Second)},
+		{"$recent", time.Now()},
+	} {
+		msg := newTestMatrixMessage(portal, send.eventID, &event.MessageEventContent{MsgType: event.MsgText, Body: "hello"})
+		client.addPendingSend(msg, &pendingSend{
+			TransactionID: networkid.TransactionID(send.eventID),
+			ChatGUID:      testChatGUID,
+			SentAt:        send.sentAt,
+			Text:          "hello",
+		})
+	}
+
+	// The loop runs without any chat.db changes, like when Messages never writes the row
+	client.OutgoingCheckStopChannel = make(chan struct{}, 1)
+	stopped := make(chan struct{})
+	go func() {
+		client.checkOutgoingLoop(10 * time.Millisecond)
+		close(stopped)
+	}()
+	matrix := client.UserLogin.Bridge.Matrix.(*testMatrixConnector)
+	var status *bridgev2.MessageStatus
+	for deadline := time.Now().Add(5 * time.Second); status == nil && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+		status = matrix.status("$expired")
+	}
+	client.OutgoingCheckStopChannel <- struct{}{}
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the loop didn't stop")
+	}
+	if status == nil || status.Status != event.MessageStatusFail || !errors.Is(status.InternalError, ErrMessageNotSent) {
+		t.Errorf("status of the expired send = %+v, want a failure", status)
+	}
+	if status := matrix.status("$recent"); status != nil {
+		t.Errorf("status of the recent send = %+v, want none yet", status)
+	}
+	echo := &macos.Message{IsFromMe: true, ChatGUID: testChatGUID, CreatedAt: time.Now(), Text: "hello"}
+	if transactionID := client.matchPendingSend(echo); transactionID != "$recent" {
+		t.Errorf("echo matched %q, want the recent send", transactionID)
+	}
+}
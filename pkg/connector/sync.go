@@ -0,0 +1,52 @@
+package connector
+
+import (
+	"context"
+
+	"github.com/rs/zerolog"
+	"maunium.net/go/mautrix/bridgev2"
+	"maunium.net/go/mautrix/bridgev2/simplevent"
+)
+
+// syncRecentChats creates portals for the most recently active chats the first time a login connects,
+// so the room list isn't empty until new messages arrive.
+func (m *MessagesClient) syncRecentChats(ctx context.Context) {
+	meta := m.UserLogin.Metadata.(*UserLoginMetadata)
+	if meta.InitialChatSyncDone {
+		return
+	}
+	count := m.Config.InitialChatSyncCount
+	if count <= 0 {
+		return
+	}
+
+	chats, err := m.MacOSMessagesClient.GetRecentChats(count)
+	if err != nil {
+		m.UserLogin.Log.Error().Msgf("Failed to get recent chats: %v", err)
+		return
+	}
+	m.UserLogin.Log.Info().Msgf("Syncing %d recent chats", len(chats))
+	for _, chat := range chats {
+		m.QueueRemoteEventWrapper(&simplevent.ChatResync{
+			EventMeta: simplevent.EventMeta{
+				Type: bridgev2.RemoteEventChatResync,
+				LogContext: func(c zerolog.Context) zerolog.Context {
+					return c.Str("chat_guid", chat.GUID)
+				},
+				PortalKey:    m.PortalKeyFromChatGUID(chat.GUID),
+				CreatePortal: true,
+				Timestamp:    chat.LastMessageAt,
+			},
+			GetChatInfoFunc: m.GetChatInfo,
+			LatestMessageTS: chat.LastMessageAt,
+		})
+	}
+
+	if m.DryRun {
+		return
+	}
+	meta.InitialChatSyncDone = true
+	if err := m.UserLogin.Save(ctx); err != nil {
+		m.UserLogin.Log.Warn().Msgf("Failed to save initial chat sync state: %v", err)
+	}
+}
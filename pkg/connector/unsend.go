@@ -0,0 +1,74 @@
+package connector
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/GroveJay/matrix-macOS-Messages-bridge/pkg/macos"
+	"maunium.net/go/mautrix/bridgev2"
+	"maunium.net/go/mautrix/bridgev2/database"
+	"maunium.net/go/mautrix/bridgev2/networkid"
+	"maunium.net/go/mautrix/event"
+)
+
+// unsentNoticePartID is the part of a fully unsent message that its notice is sent as.
+const unsentNoticePartID = networkid.PartID("unsent")
+
+// sendUnsentNotices returns whether notices are sent in place of unsent messages and parts.
+func (m *MessagesClient) sendUnsentNotices() bool {
+	return m.Config != nil && m.Config.Features.UnsendNotices
+}
+
+// ConvertRetraction redacts every part of a message that was unsent as a whole and replaces them with a notice.
+func (m *MessagesClient) ConvertRetraction(ctx context.Context, portal *bridgev2.Portal, intent bridgev2.MatrixAPI, existing []*database.Message, data macos.Message) (*bridgev2.ConvertedEdit, error) {
+	for _, existingPart := range existing {
+		if existingPart.PartID == unsentNoticePartID {
+			return nil, bridgev2.ErrIgnoringRemoteEvent
+		}
+	}
+	return &bridgev2.ConvertedEdit{
+		DeletedParts: existing,
+		AddedParts: &bridgev2.ConvertedMessage{
+			Parts: []*bridgev2.ConvertedMessagePart{m.convertUnsentNoticePart(data, unsentNoticePartID, "this message")},
+		},
+	}, nil
+}
+
+func (m *MessagesClient) convertUnsentNoticePart(data macos.Message, partID networkid.PartID, what string) *bridgev2.ConvertedMessagePart {
+	return &bridgev2.ConvertedMessagePart{
+		ID:         partID,
+		Type:       event.EventMessage,
+		Content:    data.ConvertUnsentNotice(what, m.resolveMention),
+		DBMetadata: &MessageMetadata{IsUnsentNotice: true, IndexedPartID: true},
+	}
+}
+
+// convertUnsentParts marks the notices of unsent parts so later edits leave them alone,
+// or leaves them out if unsent notices are disabled.
+func (m *MessagesClient) convertUnsentParts(data macos.Message, parts []*bridgev2.ConvertedMessagePart) []*bridgev2.ConvertedMessagePart {
+	convertedParts := make([]*bridgev2.ConvertedMessagePart, 0, len(parts))
+	for _, part := range parts {
+		if partIndex, err := strconv.Atoi(string(part.ID)); err == nil && data.IsPartUnsent(partIndex) {
+			if !m.sendUnsentNotices() {
+				continue
+			}
+			part.DBMetadata = &MessageMetadata{IsUnsentNotice: true}
+		}
+		convertedParts = append(convertedParts, part)
+	}
+	return convertedParts
+}
+
+func isUnsentNotice(part *database.Message) bool {
+	metadata, ok := part.Metadata.(*MessageMetadata)
+	return ok && metadata != nil && metadata.IsUnsentNotice
+}
+
+// retractedTimestamp is the time a message was unsent, to order the event that redacts it.
+func retractedTimestamp(message *macos.Message) time.Time {
+	if message.RetractedAt.IsZero() {
+		return time.Now()
+	}
+	return message.RetractedAt
+}
@@ -1,6 +1,11 @@
 package macos
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+)
+
+var ErrArchivableWrongType = errors.New("archivable does not hold a value of that type")
 
 type ClassResult any
 
@@ -22,12 +27,14 @@ type OutputDataTypes interface {
 	*string | *int64 | *uint64 | *float32 | *float64 | *byte | *[]byte | *Class
 }
 
+// Archivable is a decoded typedstream component. Accessors return nil for values of a different type,
+// and an error wrapping ErrArchivableWrongType if the component can't hold a value at all.
 type Archivable interface {
-	as_nsstring() *string
-	as_nsnumber_float() *float64
-	as_nsnumber_int() *int64
-	getRange() (*int64, *uint64)
-	getDictionaryLength() int
+	as_nsstring() (*string, error)
+	as_nsnumber_float() (*float64, error)
+	as_nsnumber_int() (*int64, error)
+	getRange() (*int64, *uint64, error)
+	getDictionaryLength() (int, error)
 }
 
 type Archivables interface {
@@ -80,76 +87,44 @@ func ByteToType(input byte) Type {
 	return result
 }
 
-func first_output_data_as_type[T OutputDataTypes](archivable Archivable) (output T) {
+func first_output_data_as_type[T OutputDataTypes](archivable Archivable) (output T, err error) {
 	return output_data_index_as_type[T](archivable, 0)
 }
 
-func output_data_index_as_type[T OutputDataTypes](archivable Archivable, index int) (output T) {
+func output_data_index_as_type[T OutputDataTypes](archivable Archivable, index int) (output T, err error) {
 	var outputData []any
 	switch a := archivable.(type) {
 	case ArchivableObject:
 		outputData = a.OutputData
 	case ArchivableData:
 		outputData = a.OutputData
+	case *ArchivableData:
+		outputData = a.OutputData
 	default:
-		panic(fmt.Sprintf("invalid type: %T", archivable))
+		return nil, fmt.Errorf("%w: %T has no output data", ErrArchivableWrongType, archivable)
 	}
-	// println(fmt.Sprintf("Getting index %d of length %d from outputData", index, len(outputData)))
-	if len(outputData) < (index + 1) {
-		return nil
+	if index < 0 || len(outputData) < (index+1) {
+		return nil, nil
 	}
 	if output, ok := outputData[index].(T); !ok {
-		// println(fmt.Sprintf("Conversion to %T failed", output))
-		return nil
+		return nil, nil
 	} else {
-		return output
-	}
-}
-
-func GetTextFromComponents(components []Archivable) *string {
-	if len(components) > 0 {
-		first := components[0]
-		return first.as_nsstring()
-	}
-	return nil
-}
-
-func getNDictionaryObjects(components []Archivable, startIndex int, count int) []Archivable {
-	if count == 0 {
-		return []Archivable{}
-	}
-	// This loop seems buggy. We just take items till one has a range, not the amount of items asked for?
-	finalIndex := startIndex + count
-	for currentIndex := startIndex; currentIndex < len(components); currentIndex++ {
-		currentStart, currentEnd := components[currentIndex].getRange()
-		if currentStart != nil && currentEnd != nil {
-			break
-		}
-		finalIndex = currentIndex
+		return output, nil
 	}
-	return components[startIndex:(finalIndex + 1)]
 }
 
-func ResolveStyles(components []Archivable) []Style {
+func ResolveStyles(attributes AttributeDictionary) []Style {
 	resolvedStyles := []Style{}
-	for _, component := range components {
-		keyName := component.as_nsstring()
-		if keyName != nil {
-			var keyNameAsInterface interface{} = *keyName
-			if keyNameAsComponentTypeKey, ok := keyNameAsInterface.(ComponentTypeKey); !ok {
-				continue
-			} else {
-				switch keyNameAsComponentTypeKey {
-				case TextBoldAttributeName:
-					resolvedStyles = append(resolvedStyles, StyleBold)
-				case TextUnderlineAttributeName:
-					resolvedStyles = append(resolvedStyles, StyleUnderline)
-				case TextItalicAttributeName:
-					resolvedStyles = append(resolvedStyles, StyleItalic)
-				case TextStrikethroughAttributeName:
-					resolvedStyles = append(resolvedStyles, StyleStrikethrough)
-				}
-			}
+	for _, entry := range attributes {
+		switch ComponentTypeKey(entry.Key) {
+		case TextBoldAttributeName:
+			resolvedStyles = append(resolvedStyles, StyleBold)
+		case TextUnderlineAttributeName:
+			resolvedStyles = append(resolvedStyles, StyleUnderline)
+		case TextItalicAttributeName:
+			resolvedStyles = append(resolvedStyles, StyleItalic)
+		case TextStrikethroughAttributeName:
+			resolvedStyles = append(resolvedStyles, StyleStrikethrough)
 		}
 	}
 	return resolvedStyles
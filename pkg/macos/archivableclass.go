@@ -1,27 +1,29 @@
 package macos
 
+import "fmt"
+
 type ArchivableClass struct {
 	Class Class
 }
 
 var _ Archivable = (*ArchivableClass)(nil)
 
-func (a ArchivableClass) as_nsstring() *string {
-	panic("Class type cannot be parsed as string")
+func (a ArchivableClass) as_nsstring() (*string, error) {
+	return nil, fmt.Errorf("%w: Class type cannot be parsed as string", ErrArchivableWrongType)
 }
 
-func (a ArchivableClass) as_nsnumber_float() *float64 {
-	panic("Class type cannot be parsed as float")
+func (a ArchivableClass) as_nsnumber_float() (*float64, error) {
+	return nil, fmt.Errorf("%w: Class type cannot be parsed as float", ErrArchivableWrongType)
 }
 
-func (a ArchivableClass) as_nsnumber_int() *int64 {
-	panic("Class type cannot be parsed as string")
+func (a ArchivableClass) as_nsnumber_int() (*int64, error) {
+	return nil, fmt.Errorf("%w: Class type cannot be parsed as int", ErrArchivableWrongType)
 }
 
-func (a ArchivableClass) getRange() (*int64, *uint64) {
-	panic("Cannot get range from Class type")
+func (a ArchivableClass) getRange() (*int64, *uint64, error) {
+	return nil, nil, fmt.Errorf("%w: cannot get range from Class type", ErrArchivableWrongType)
 }
 
-func (a ArchivableClass) getDictionaryLength() int {
-	panic("Cannot get dictionary length from Class type")
+func (a ArchivableClass) getDictionaryLength() (int, error) {
+	return 0, fmt.Errorf("%w: cannot get dictionary length from Class type", ErrArchivableWrongType)
 }
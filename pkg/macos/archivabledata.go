@@ -1,40 +1,40 @@
 package macos
 
+import "fmt"
+
 type ArchivableData struct {
 	OutputData []any
 }
 
 var _ Archivable = (*ArchivableData)(nil)
 
-func (a ArchivableData) as_nsstring() *string {
-	return nil
-	// panic("Data type cannot be parsed as string")
+func (a ArchivableData) as_nsstring() (*string, error) {
+	return nil, nil
 }
 
-func (a ArchivableData) as_nsnumber_float() *float64 {
-	panic("Data type cannot be parsed as float")
+func (a ArchivableData) as_nsnumber_float() (*float64, error) {
+	return nil, fmt.Errorf("%w: Data type cannot be parsed as float", ErrArchivableWrongType)
 }
 
-func (a ArchivableData) as_nsnumber_int() *int64 {
-	panic("Data type cannot be parsed as string")
+func (a ArchivableData) as_nsnumber_int() (*int64, error) {
+	return nil, fmt.Errorf("%w: Data type cannot be parsed as int", ErrArchivableWrongType)
 }
 
-func (a ArchivableData) getRange() (*int64, *uint64) {
+func (a ArchivableData) getRange() (*int64, *uint64, error) {
 	if len(a.OutputData) != 2 {
-		return nil, nil
+		return nil, nil, nil
 	}
-	rangeStart := first_output_data_as_type[*int64](a)
-	if rangeStart == nil {
-		return nil, nil
+	rangeStart, err := first_output_data_as_type[*int64](a)
+	if err != nil || rangeStart == nil {
+		return nil, nil, err
 	}
-	rangeEnd := output_data_index_as_type[*uint64](a, 1)
-	if rangeEnd == nil {
-		return nil, nil
+	rangeEnd, err := output_data_index_as_type[*uint64](a, 1)
+	if err != nil || rangeEnd == nil {
+		return nil, nil, err
 	}
-	return rangeStart, rangeEnd
+	return rangeStart, rangeEnd, nil
 }
 
-func (a ArchivableData) getDictionaryLength() int {
-	return 0
-	// panic("Cannot get dictionary length from Data type")
+func (a ArchivableData) getDictionaryLength() (int, error) {
+	return 0, nil
 }
@@ -1,51 +1,49 @@
 package macos
 
+import "fmt"
+
 type ArchivableObject struct {
 	ArchivableData
 	ArchivableClass
 }
 
-var _ Archivable = (*ArchivablePlaceholder)(nil)
+var _ Archivable = (*ArchivableObject)(nil)
 
-func (a ArchivableObject) as_nsstring() *string {
+func (a ArchivableObject) as_nsstring() (*string, error) {
 	if a.Class.name != "NSString" && a.Class.name != "NSMutableString" {
-		return nil
-	} else {
-		result := first_output_data_as_type[*string](a)
-		// println("Got string for ArchivableObject: %s", &result)
-		return result
+		return nil, nil
 	}
+	return first_output_data_as_type[*string](a)
 }
 
-func (a ArchivableObject) as_nsnumber_float() *float64 {
+func (a ArchivableObject) as_nsnumber_float() (*float64, error) {
 	if a.Class.name != "NSNumber" {
-		return nil
-	} else {
-		return first_output_data_as_type[*float64](a)
+		return nil, nil
 	}
+	return first_output_data_as_type[*float64](a)
 }
 
-func (a ArchivableObject) as_nsnumber_int() *int64 {
+func (a ArchivableObject) as_nsnumber_int() (*int64, error) {
 	if a.Class.name != "NSNumber" {
-		return nil
-	} else {
-		return first_output_data_as_type[*int64](a)
+		return nil, nil
 	}
+	return first_output_data_as_type[*int64](a)
 }
 
-func (a ArchivableObject) getRange() (*int64, *uint64) {
-	return nil, nil
-	// panic("Cannot get range from ArchivableObject type")
+func (a ArchivableObject) getRange() (*int64, *uint64, error) {
+	return nil, nil, nil
 }
 
-func (a ArchivableObject) getDictionaryLength() int {
+func (a ArchivableObject) getDictionaryLength() (int, error) {
 	if a.Class.name != "NSDictionary" {
-		return 0
-	} else {
-		length := first_output_data_as_type[*int64](a)
-		if length == nil {
-			return 0
-		}
-		return int(*length) * 2
+		return 0, nil
+	}
+	length, err := first_output_data_as_type[*int64](a)
+	if err != nil || length == nil {
+		return 0, err
+	}
+	if *length < 0 {
+		return 0, fmt.Errorf("%w: negative dictionary length %d", ErrArchivableWrongType, *length)
 	}
+	return int(*length) * 2, nil
 }
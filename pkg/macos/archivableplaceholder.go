@@ -1,25 +1,27 @@
 package macos
 
+import "fmt"
+
 type ArchivablePlaceholder struct{}
 
 var _ Archivable = (*ArchivablePlaceholder)(nil)
 
-func (a ArchivablePlaceholder) as_nsstring() *string {
-	panic("Placeholder type cannot be parsed as string")
+func (a ArchivablePlaceholder) as_nsstring() (*string, error) {
+	return nil, fmt.Errorf("%w: Placeholder type cannot be parsed as string", ErrArchivableWrongType)
 }
 
-func (a ArchivablePlaceholder) as_nsnumber_float() *float64 {
-	panic("Placeholder type cannot be parsed as float")
+func (a ArchivablePlaceholder) as_nsnumber_float() (*float64, error) {
+	return nil, fmt.Errorf("%w: Placeholder type cannot be parsed as float", ErrArchivableWrongType)
 }
 
-func (a ArchivablePlaceholder) as_nsnumber_int() *int64 {
-	panic("Placeholder type cannot be parsed as string")
+func (a ArchivablePlaceholder) as_nsnumber_int() (*int64, error) {
+	return nil, fmt.Errorf("%w: Placeholder type cannot be parsed as int", ErrArchivableWrongType)
 }
 
-func (a ArchivablePlaceholder) getRange() (*int64, *uint64) {
-	panic("Cannot get range from Placeholder type")
+func (a ArchivablePlaceholder) getRange() (*int64, *uint64, error) {
+	return nil, nil, fmt.Errorf("%w: cannot get range from Placeholder type", ErrArchivableWrongType)
 }
 
-func (a ArchivablePlaceholder) getDictionaryLength() int {
-	panic("Cannot get dictionary length from Placeholder type")
+func (a ArchivablePlaceholder) getDictionaryLength() (int, error) {
+	return 0, fmt.Errorf("%w: cannot get dictionary length from Placeholder type", ErrArchivableWrongType)
 }
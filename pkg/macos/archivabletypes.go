@@ -1,27 +1,29 @@
 package macos
 
+import "fmt"
+
 type ArchivableTypes struct {
 	Types []Type
 }
 
 var _ Archivable = (*ArchivableTypes)(nil)
 
-func (a ArchivableTypes) as_nsstring() *string {
-	panic("Placeholder type cannot be parsed as string")
+func (a ArchivableTypes) as_nsstring() (*string, error) {
+	return nil, fmt.Errorf("%w: Types type cannot be parsed as string", ErrArchivableWrongType)
 }
 
-func (a ArchivableTypes) as_nsnumber_float() *float64 {
-	panic("Placeholder type cannot be parsed as float")
+func (a ArchivableTypes) as_nsnumber_float() (*float64, error) {
+	return nil, fmt.Errorf("%w: Types type cannot be parsed as float", ErrArchivableWrongType)
 }
 
-func (a ArchivableTypes) as_nsnumber_int() *int64 {
-	panic("Placeholder type cannot be parsed as string")
+func (a ArchivableTypes) as_nsnumber_int() (*int64, error) {
+	return nil, fmt.Errorf("%w: Types type cannot be parsed as int", ErrArchivableWrongType)
 }
 
-func (a ArchivableTypes) getRange() (*int64, *uint64) {
-	panic("Cannot get range from Placeholder type")
+func (a ArchivableTypes) getRange() (*int64, *uint64, error) {
+	return nil, nil, fmt.Errorf("%w: cannot get range from Types type", ErrArchivableWrongType)
 }
 
-func (a ArchivableTypes) getDictionaryLength() int {
-	panic("Cannot get dictionary length from Placeholder type")
+func (a ArchivableTypes) getDictionaryLength() (int, error) {
+	return 0, fmt.Errorf("%w: cannot get dictionary length from Types type", ErrArchivableWrongType)
 }
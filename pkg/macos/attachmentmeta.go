@@ -8,34 +8,22 @@ type AttachmentMeta struct {
 	Name          *string
 }
 
-func GetAttachmentMetaFromComponents(components []Archivable) *AttachmentMeta {
+func GetAttachmentMetaFromAttributes(attributes AttributeDictionary) *AttachmentMeta {
 	attachmentMeta := AttachmentMeta{}
-	for index, component := range components {
-		keyName := component.as_nsstring()
-		if keyName != nil {
-			var keyNameAsInterface any = *keyName
-			if keyNameAsComponentTypeKey, ok := keyNameAsInterface.(ComponentTypeKey); !ok {
-				continue
-			} else {
-				nextIndex := index + 1
-				if nextIndex >= len(components) {
-					return nil
-				}
-				nextComponent := components[nextIndex]
-				switch keyNameAsComponentTypeKey {
-				case FileTransferGUIDAttributeName:
-					attachmentMeta.GUID = nextComponent.as_nsstring()
-				case AudioTranscription:
-					attachmentMeta.Transcription = nextComponent.as_nsstring()
-				case InlineMediaHeightAttributeName:
-					attachmentMeta.Height = nextComponent.as_nsnumber_float()
-				case InlineMediaWidthAttributeName:
-					attachmentMeta.Width = nextComponent.as_nsnumber_float()
-				case FilenameAttributeName:
-					attachmentMeta.Name = nextComponent.as_nsstring()
-				}
-			}
-		}
+	if guid, ok := attributes.GetString(FileTransferGUIDAttributeName); ok {
+		attachmentMeta.GUID = &guid
+	}
+	if transcription, ok := attributes.GetString(AudioTranscription); ok {
+		attachmentMeta.Transcription = &transcription
+	}
+	if height, ok := attributes.GetFloat(InlineMediaHeightAttributeName); ok {
+		attachmentMeta.Height = &height
+	}
+	if width, ok := attributes.GetFloat(InlineMediaWidthAttributeName); ok {
+		attachmentMeta.Width = &width
+	}
+	if name, ok := attributes.GetString(FilenameAttributeName); ok {
+		attachmentMeta.Name = &name
 	}
 	return &attachmentMeta
 }
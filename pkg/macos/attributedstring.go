@@ -0,0 +1,252 @@
+package macos
+
+import (
+	"fmt"
+	"unicode/utf16"
+)
+
+// AttributedString is a model of the NSAttributedString archived in a message's attributedBody.
+type AttributedString struct {
+	String string
+	Runs   []AttributeRun
+}
+
+// AttributeRun applies a set of attributes to part of the string.
+// Location and Length count UTF-16 code units, like an NSRange.
+type AttributeRun struct {
+	Location   int
+	Length     int
+	Attributes AttributeDictionary
+}
+
+// AttributeDictionary is an NSDictionary with string keys, kept in the order it was archived in.
+// Values are a string, NSURL, bool, int, int32, int64, float64 or a nested AttributeDictionary.
+// Decoded numbers are always int64 or float64, and values of other classes are nil.
+type AttributeDictionary []AttributeEntry
+
+type AttributeEntry struct {
+	Key   string
+	Value any
+}
+
+// NSURL is a URL that is archived as an NSURL object rather than an NSString, as link attributes are.
+type NSURL string
+
+// DecodeAttributedString decodes an attributedBody into its string and attribute runs.
+// Attributes that can't be made sense of are left out rather than failing the whole string.
+func DecodeAttributedString(encoded []byte) (*AttributedString, error) {
+	components, err := DecodeTypedStreamComponents(encoded)
+	if err != nil {
+		return nil, err
+	}
+	return attributedStringFromComponents(components)
+}
+
+// attributedStringFromComponents reads the string, followed by each run as an attribute dictionary index and a length.
+// The dictionary follows the run the first time its index is used, and later runs refer back to it.
+func attributedStringFromComponents(components []Archivable) (*AttributedString, error) {
+	if len(components) == 0 {
+		return nil, fmt.Errorf("%w: attributed string has no components", ErrArchivableWrongType)
+	}
+	text, err := components[0].as_nsstring()
+	if err != nil {
+		return nil, err
+	} else if text == nil {
+		return nil, fmt.Errorf("%w: attributed string doesn't start with a string", ErrArchivableWrongType)
+	}
+
+	attributedString := &AttributedString{String: *text}
+	reader := &componentReader{components: components, index: 1}
+	var dictionaries []AttributeDictionary
+	location := 0
+	for reader.index < len(components) {
+		dictionaryIndex, length, err := components[reader.index].getRange()
+		reader.index += 1
+		if err != nil || dictionaryIndex == nil || length == nil {
+			// Anything we couldn't read as part of a dictionary is skipped up to the next run
+			continue
+		}
+		var attributes AttributeDictionary
+		if reader.peekClass() == "NSDictionary" {
+			attributes = reader.readDictionary()
+			dictionaries = append(dictionaries, attributes)
+		} else if *dictionaryIndex >= 1 && int(*dictionaryIndex) <= len(dictionaries) {
+			attributes = dictionaries[*dictionaryIndex-1]
+		}
+		attributedString.Runs = append(attributedString.Runs, AttributeRun{
+			Location:   location,
+			Length:     int(*length),
+			Attributes: attributes,
+		})
+		location += int(*length)
+	}
+	return attributedString, nil
+}
+
+// componentReader walks the objects of an archived value, which the decoder flattens into consecutive components.
+type componentReader struct {
+	components []Archivable
+	index      int
+}
+
+func (r *componentReader) peekClass() string {
+	if r.index >= len(r.components) {
+		return ""
+	}
+	if object, ok := r.components[r.index].(ArchivableObject); ok {
+		return object.Class.name
+	}
+	return ""
+}
+
+// readDictionary reads a dictionary and its keys and values, stopping early at anything that isn't a string key.
+func (r *componentReader) readDictionary() AttributeDictionary {
+	length, err := r.components[r.index].getDictionaryLength()
+	r.index += 1
+	if err != nil {
+		return nil
+	}
+	dictionary := make(AttributeDictionary, 0, min(length/2, len(r.components)-r.index))
+	for range length / 2 {
+		if r.index >= len(r.components) {
+			break
+		}
+		key, ok := r.readValue().(string)
+		if !ok || r.index >= len(r.components) {
+			break
+		}
+		dictionary = append(dictionary, AttributeEntry{
+			Key:   key,
+			Value: r.readValue(),
+		})
+	}
+	return dictionary
+}
+
+func (r *componentReader) readValue() any {
+	object, ok := r.components[r.index].(ArchivableObject)
+	if !ok {
+		r.index += 1
+		return nil
+	}
+	switch object.Class.name {
+	case "NSDictionary":
+		return r.readDictionary()
+	case "NSURL":
+		// The URL's string is archived as its own object right after it
+		r.index += 1
+		if r.peekClass() != "NSString" {
+			return nil
+		}
+		if value, ok := r.readValue().(string); ok {
+			return NSURL(value)
+		}
+		return nil
+	}
+
+	r.index += 1
+	switch object.Class.name {
+	case "NSString", "NSMutableString":
+		if value, _ := object.as_nsstring(); value != nil {
+			return *value
+		}
+	case "NSNumber":
+		if len(object.OutputData) == 0 {
+			return nil
+		}
+		switch value := object.OutputData[0].(type) {
+		case *int64:
+			return *value
+		case *uint64:
+			return int64(*value)
+		case *float64:
+			return *value
+		case *float32:
+			return float64(*value)
+		}
+	}
+	return nil
+}
+
+func (d AttributeDictionary) Get(key ComponentTypeKey) (any, bool) {
+	for _, entry := range d {
+		if entry.Key == string(key) {
+			return entry.Value, true
+		}
+	}
+	return nil, false
+}
+
+func (d AttributeDictionary) Has(key ComponentTypeKey) bool {
+	_, ok := d.Get(key)
+	return ok
+}
+
+// GetString returns the value of a string or NSURL attribute.
+func (d AttributeDictionary) GetString(key ComponentTypeKey) (string, bool) {
+	value, _ := d.Get(key)
+	switch typedValue := value.(type) {
+	case string:
+		return typedValue, true
+	case NSURL:
+		return string(typedValue), true
+	}
+	return "", false
+}
+
+func (d AttributeDictionary) GetInt(key ComponentTypeKey) (int64, bool) {
+	value, _ := d.Get(key)
+	switch typedValue := value.(type) {
+	case int64:
+		return typedValue, true
+	case int:
+		return int64(typedValue), true
+	case int32:
+		return int64(typedValue), true
+	case bool:
+		if typedValue {
+			return 1, true
+		}
+		return 0, true
+	case float64:
+		return int64(typedValue), true
+	}
+	return 0, false
+}
+
+func (d AttributeDictionary) GetFloat(key ComponentTypeKey) (float64, bool) {
+	if value, _ := d.Get(key); value != nil {
+		if floatValue, ok := value.(float64); ok {
+			return floatValue, true
+		}
+	}
+	intValue, ok := d.GetInt(key)
+	return float64(intValue), ok
+}
+
+// utf16ByteOffsets maps each UTF-16 offset in s, up to and including its length, to the byte offset in s.
+// Offsets that fall between the two halves of a surrogate pair map to the start of that character.
+func utf16ByteOffsets(s string) []int {
+	offsets := make([]int, 0, len(s)+1)
+	for byteOffset, r := range s {
+		for range utf16.RuneLen(r) {
+			offsets = append(offsets, byteOffset)
+		}
+	}
+	return append(offsets, len(s))
+}
+
+// byteRange converts a UTF-16 range of the string to byte offsets, clamped to the string.
+func byteRange(offsets []int, location int, length int) (int, int) {
+	start := offsets[min(max(location, 0), len(offsets)-1)]
+	end := offsets[min(max(location+length, 0), len(offsets)-1)]
+	return start, max(start, end)
+}
+
+func utf16Length(s string) int {
+	length := 0
+	for _, r := range s {
+		length += utf16.RuneLen(r)
+	}
+	return length
+}
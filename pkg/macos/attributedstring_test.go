@@ -0,0 +1,109 @@
+package macos
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestUTF16ByteOffsets(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want []int
+	}{
+		{"empty", "", []int{0}},
+		{"ascii", "abc", []int{0, 1, 2, 3}},
+		{"two byte", "é!", []int{0, 2, 3}},
+		{"emoji", "a😀b", []int{0, 1, 1, 5, 6}},
+		{"zwj sequence", "👨‍👧", []int{0, 0, 4, 7, 7, 11}},
+		{"flag", "🇳🇱", []int{0, 0, 4, 4, 8}},
+		{"combining mark", "é", []int{0, 1, 3}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := utf16ByteOffsets(test.text); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("utf16ByteOffsets(%q) = %v, want %v", test.text, got, test.want)
+			}
+		})
+	}
+}
+
+func TestByteRange(t *testing.T) {
+	offsets := utf16ByteOffsets("a😀b")
+	tests := []struct {
+		name             string
+		location, length int
+		wantStart        int
+		wantEnd          int
+	}{
+		{"whole string", 0, 4, 0, 6},
+		{"emoji", 1, 2, 1, 5},
+		{"starts mid-surrogate", 2, 2, 1, 6},
+		{"ends mid-surrogate", 0, 2, 0, 1},
+		{"past the end", 3, 10, 5, 6},
+		{"entirely past the end", 10, 2, 6, 6},
+		{"negative location", -2, 3, 0, 1},
+		{"negative length", 3, -2, 5, 5},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			start, end := byteRange(offsets, test.location, test.length)
+			if start != test.wantStart || end != test.wantEnd {
+				t.Errorf("byteRange(%d, %d) = [%d, %d), want [%d, %d)", test.location, test.length, start, end, test.wantStart, test.wantEnd)
+			}
+		})
+	}
+}
+
+// TestRenderAttributedStringRanges renders attributed strings whose formatted run covers characters of more than
+// one UTF-16 code unit. The text around the run gets unformatted runs, like in the archives Messages writes.
+func TestRenderAttributedStringRanges(t *testing.T) {
+	bold := AttributeDictionary{{Key: string(TextBoldAttributeName), Value: 1}}
+	tests := []struct {
+		name              string
+		text              string
+		location, length  int
+		attributes        AttributeDictionary
+		wantBody          string
+		wantFormattedBody string
+	}{
+		{"emoji", "hi 👋 there", 3, 2, bold, "hi **👋** there", "hi <strong>👋</strong> there"},
+		{"zwj sequence", "👨‍👩‍👧 family", 0, 8, AttributeDictionary{{Key: string(TextItalicAttributeName), Value: 1}},
+			"_👨‍👩‍👧_ family", "<em>👨‍👩‍👧</em> family"},
+		{"flags", "🇳🇱🇯🇵 go", 4, 4, AttributeDictionary{{Key: string(TextStrikethroughAttributeName), Value: 1}},
+			"🇳🇱~~🇯🇵~~ go", "🇳🇱<del>🇯🇵</del> go"},
+		{"combining mark", "café ok", 0, 5, AttributeDictionary{{Key: string(TextUnderlineAttributeName), Value: 1}},
+			"café ok", "<u>café</u> ok"},
+		{"starts mid-surrogate", "a😀b", 2, 2, bold, "a**😀b**", "a<strong>😀b</strong>"},
+		{"ends mid-surrogate", "a😀b", 0, 2, bold, "**a**😀b", "<strong>a</strong>😀b"},
+		{"past the end", "abc def", 4, 100, bold, "abc **def**", "abc <strong>def</strong>"},
+		{"entirely past the end", "abc", 10, 2, bold, "abc", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			runs := []AttributeRun{}
+			if test.location > 0 {
+				runs = append(runs, AttributeRun{Location: 0, Length: test.location})
+			}
+			runs = append(runs, AttributeRun{Location: test.location, Length: test.length, Attributes: test.attributes})
+			if end := test.location + test.length; end < utf16Length(test.text) {
+				runs = append(runs, AttributeRun{Location: end, Length: utf16Length(test.text) - end})
+			}
+			components := ConvertAttributedStringToCombinedComponents(&AttributedString{String: test.text, Runs: runs})
+			if len(components) != 1 {
+				t.Fatalf("got %d components, want 1", len(components))
+			}
+			text, ok := components[0].(CombinedComponentText)
+			if !ok {
+				t.Fatalf("got %T, want CombinedComponentText", components[0])
+			}
+			body, formattedBody := RenderTextRangeEffects(test.text, text.TextRangeEffects)
+			if body != test.wantBody {
+				t.Errorf("body = %q, want %q", body, test.wantBody)
+			}
+			if formattedBody != test.wantFormattedBody {
+				t.Errorf("formattedBody = %q, want %q", formattedBody, test.wantFormattedBody)
+			}
+		})
+	}
+}
@@ -25,7 +25,10 @@ type ContactsDB struct {
 }
 
 type MacOSContactsClient struct {
-	contactsDBs []*ContactsDB
+	contactsDBs  []*ContactsDB
+	scriptRunner ScriptRunner
+	// Region to parse contact phone numbers without a country code in
+	defaultRegion string
 }
 
 func createAndPrepareContactsDB(path string) (contactsDB *ContactsDB, err error) {
@@ -42,12 +45,11 @@ func createAndPrepareContactsDB(path string) (contactsDB *ContactsDB, err error)
 	return contactsDB, nil
 }
 
-func openContactsDBs() (contactsDBs []*ContactsDB, err error) {
-	path, err := os.UserHomeDir()
+func openContactsDBs(paths DataPaths) (contactsDBs []*ContactsDB, err error) {
+	contactsSourcesPath, err := paths.GetAddressBookSourcesPath()
 	if err != nil {
-		return nil, fmt.Errorf("failed to get home directory: %w", err)
+		return nil, err
 	}
-	contactsSourcesPath := filepath.Join(path, "Library", "Application Support", "AddressBook", "Sources")
 	if sourcePaths, err := os.ReadDir(contactsSourcesPath); err != nil {
 		return nil, fmt.Errorf("failed to read directory %s: %w", contactsSourcesPath, err)
 	} else {
@@ -69,10 +71,13 @@ func openContactsDBs() (contactsDBs []*ContactsDB, err error) {
 	return contactsDBs, nil
 }
 
-func GetContactsClient(userName string) (*MacOSContactsClient, error) {
-	client := &MacOSContactsClient{}
+func GetContactsClient(userName string, paths DataPaths, defaultRegion string, scriptRunner ScriptRunner) (*MacOSContactsClient, error) {
+	client := &MacOSContactsClient{
+		scriptRunner:  scriptRunner,
+		defaultRegion: defaultRegion,
+	}
 	var err error
-	if client.contactsDBs, err = openContactsDBs(); err != nil {
+	if client.contactsDBs, err = openContactsDBs(paths); err != nil {
 		return nil, err
 	}
 	return client, nil
@@ -120,7 +125,7 @@ func (c MacOSContactsClient) GetContactsMap() (map[networkid.UserID]ContactInfor
 				contactsMap[networkid.UserID(email)] = contactInformation
 			}
 			if len(phoneNumber) != 0 {
-				if userID, err := ParseFormatPhoneNumber(phoneNumber, "US"); err != nil {
+				if userID, err := ParseFormatPhoneNumber(phoneNumber, c.defaultRegion); err != nil {
 					errors = errors + "\n" + err.Error()
 					continue
 				} else {
@@ -140,7 +145,7 @@ func (c MacOSContactsClient) GetWrappedAvatarForID(ID string) *bridgev2.Avatar {
 	if ID == "" {
 		return &bridgev2.Avatar{Remove: true}
 	}
-	vcardResult, stderr, err := RunOsascript(GetContactVCard, ID)
+	vcardResult, stderr, err := c.scriptRunner.RunScript(GetContactVCard, ID)
 	if err != nil || len(vcardResult) == 0 || len(stderr) != 0 {
 		return &bridgev2.Avatar{Remove: true}
 	}
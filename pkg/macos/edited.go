@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"fmt"
 	"strconv"
+	"time"
 
 	"howett.net/plist"
 )
@@ -34,14 +35,19 @@ type EditedMessagePart struct {
 }
 
 type EditedEvent struct {
-	Date       int64
-	Text       *string
-	Components []Archivable
-	GUID       *string
+	Date           int64
+	Text           *string
+	AttributedBody *AttributedString
+	GUID           *string
 }
 
 const TIMESTAMP_FACTOR = 1000000000
 
+// GetTime returns when the edit was made.
+func (e EditedEvent) GetTime() time.Time {
+	return time.Unix(AppleEpochUnix, e.Date)
+}
+
 func GetValueAsMapFromMapKey(input map[string]any, key string) (map[string]any, error) {
 	if entry, ok := input[key]; !ok {
 		return nil, fmt.Errorf("no '%s' key in input map", key)
@@ -150,11 +156,10 @@ func EditedMessagePartsFromMessageSummaryInfo(messageSummaryInfo []byte) ([]*Edi
 					return nil, fmt.Errorf("casting typedstream key 't' to []byte: %w", err)
 				}
 
-				components, err := DecodeTypedStreamComponents(typedstreamBytes)
+				attributedString, err := DecodeAttributedString(typedstreamBytes)
 				if err != nil {
-					return nil, fmt.Errorf("getting typedstream components: %w", err)
+					return nil, fmt.Errorf("decoding typedstream: %w", err)
 				}
-				text := GetTextFromComponents(components)
 
 				// It's ok if guid is null?
 				guid, _ := GetValueAsStringFromMapKey(data, "bcg")
@@ -162,10 +167,10 @@ func EditedMessagePartsFromMessageSummaryInfo(messageSummaryInfo []byte) ([]*Edi
 				if parsedKey >= 0 && parsedKey < len(editedMessageParts) {
 					editedMessageParts[parsedKey].Status = EditedMessageStatusEdited
 					editedMessageParts[parsedKey].EditHistory = append(editedMessageParts[parsedKey].EditHistory, EditedEvent{
-						Date:       date,
-						Text:       text,
-						Components: components,
-						GUID:       guid,
+						Date:           date,
+						Text:           &attributedString.String,
+						AttributedBody: attributedString,
+						GUID:           guid,
 					})
 				}
 			}
@@ -0,0 +1,103 @@
+package macostest
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/GroveJay/matrix-macOS-Messages-bridge/pkg/macos"
+)
+
+// The parts of the AddressBook schema read by the macos package.
+const addressBookSchema = `
+CREATE TABLE ZABCDRECORD (
+	Z_PK       INTEGER PRIMARY KEY,
+	ZUNIQUEID  VARCHAR,
+	ZFIRSTNAME VARCHAR,
+	ZLASTNAME  VARCHAR,
+	ZNICKNAME  VARCHAR
+);
+CREATE TABLE ZABCDPHONENUMBER (
+	Z_PK        INTEGER PRIMARY KEY,
+	ZOWNER      INTEGER,
+	ZFULLNUMBER VARCHAR
+);
+CREATE TABLE ZABCDEMAILADDRESS (
+	Z_PK               INTEGER PRIMARY KEY,
+	ZOWNER             INTEGER,
+	ZADDRESSNORMALIZED VARCHAR
+);
+`
+
+// AddressBook builds one synthetic AddressBook source in a fake macOS home directory.
+// A home directory can hold several, like a Mac with more than one contacts account.
+type AddressBook struct {
+	t  testing.TB
+	db *sql.DB
+	// The source's database, in its own directory under the AddressBook sources
+	Path string
+}
+
+// NewAddressBook creates an empty AddressBook source in the home directory,
+// where macos.DataPaths looks for them by default.
+func NewAddressBook(t testing.TB, home string) *AddressBook {
+	t.Helper()
+	sourcesPath, err := macos.DataPaths{Home: home}.GetAddressBookSourcesPath()
+	if err != nil {
+		t.Fatalf("getting AddressBook sources path: %v", err)
+	}
+	if err = os.MkdirAll(sourcesPath, 0700); err != nil {
+		t.Fatalf("creating AddressBook sources directory: %v", err)
+	}
+	sourcePath, err := os.MkdirTemp(sourcesPath, "source-")
+	if err != nil {
+		t.Fatalf("creating AddressBook source directory: %v", err)
+	}
+	path := filepath.Join(sourcePath, "AddressBook-v22.abcddb")
+	db, err := sql.Open("sqlite3", fmt.Sprintf("file:%s", path))
+	if err != nil {
+		t.Fatalf("opening AddressBook: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+	if _, err = db.Exec(addressBookSchema); err != nil {
+		t.Fatalf("creating AddressBook schema: %v", err)
+	}
+	return &AddressBook{
+		t:    t,
+		db:   db,
+		Path: path,
+	}
+}
+
+// Contact adds a person with the given phone numbers and emails, and returns its unique ID.
+// Handles containing an @ are emails, anything else is a phone number.
+func (a *AddressBook) Contact(firstName, lastName, nickname string, handles ...string) string {
+	a.t.Helper()
+	result, err := a.db.Exec(`INSERT INTO ZABCDRECORD (ZFIRSTNAME, ZLASTNAME, ZNICKNAME) VALUES ($1, $2, $3)`, firstName, lastName, nickname)
+	if err != nil {
+		a.t.Fatalf("inserting contact: %v", err)
+	}
+	owner, err := result.LastInsertId()
+	if err != nil {
+		a.t.Fatalf("getting inserted contact ID: %v", err)
+	}
+	uniqueID := fmt.Sprintf("%08X-CONTACT:ABPerson", owner)
+	if _, err = a.db.Exec(`UPDATE ZABCDRECORD SET ZUNIQUEID=$1 WHERE Z_PK=$2`, uniqueID, owner); err != nil {
+		a.t.Fatalf("setting contact unique ID: %v", err)
+	}
+	for _, handle := range handles {
+		query := `INSERT INTO ZABCDPHONENUMBER (ZOWNER, ZFULLNUMBER) VALUES ($1, $2)`
+		if strings.Contains(handle, "@") {
+			query = `INSERT INTO ZABCDEMAILADDRESS (ZOWNER, ZADDRESSNORMALIZED) VALUES ($1, $2)`
+		}
+		if _, err = a.db.Exec(query, owner, handle); err != nil {
+			a.t.Fatalf("inserting contact handle %s: %v", handle, err)
+		}
+	}
+	return uniqueID
+}
@@ -0,0 +1,547 @@
+package macostest
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/GroveJay/matrix-macOS-Messages-bridge/pkg/macos"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// The parts of the chat.db schema read by the macos package. Columns default the same way as on a Mac,
+// so rows only need to set what a test cares about.
+const chatDBSchema = `
+CREATE TABLE handle (
+	ROWID   INTEGER PRIMARY KEY AUTOINCREMENT UNIQUE,
+	id      TEXT NOT NULL,
+	country TEXT,
+	service TEXT NOT NULL
+);
+CREATE TABLE chat (
+	ROWID        INTEGER PRIMARY KEY AUTOINCREMENT,
+	guid         TEXT UNIQUE NOT NULL,
+	display_name TEXT,
+	group_id     TEXT
+);
+CREATE TABLE chat_handle_join (
+	chat_id   INTEGER REFERENCES chat (ROWID) ON DELETE CASCADE,
+	handle_id INTEGER REFERENCES handle (ROWID) ON DELETE CASCADE,
+	UNIQUE(chat_id, handle_id)
+);
+CREATE TABLE message (
+	ROWID                    INTEGER PRIMARY KEY AUTOINCREMENT,
+	guid                     TEXT UNIQUE NOT NULL,
+	text                     TEXT,
+	subject                  TEXT,
+	attributedBody           BLOB,
+	message_summary_info     BLOB,
+	handle_id                INTEGER DEFAULT 0,
+	other_handle             INTEGER DEFAULT 0,
+	service                  TEXT,
+	error                    INTEGER DEFAULT 0,
+	date                     INTEGER,
+	date_read                INTEGER,
+	date_delivered           INTEGER,
+	date_edited              INTEGER DEFAULT 0,
+	date_retracted           INTEGER DEFAULT 0,
+	is_delivered             INTEGER DEFAULT 0,
+	is_from_me               INTEGER DEFAULT 0,
+	is_read                  INTEGER DEFAULT 0,
+	is_sent                  INTEGER DEFAULT 0,
+	is_emote                 INTEGER DEFAULT 0,
+	is_audio_message         INTEGER DEFAULT 0,
+	item_type                INTEGER DEFAULT 0,
+	group_title              TEXT,
+	group_action_type        INTEGER DEFAULT 0,
+	associated_message_guid  TEXT,
+	associated_message_type  INTEGER DEFAULT 0,
+	associated_message_emoji TEXT,
+	balloon_bundle_id        TEXT,
+	thread_originator_guid   TEXT,
+	thread_originator_part   TEXT
+);
+CREATE TABLE chat_message_join (
+	chat_id      INTEGER REFERENCES chat (ROWID) ON DELETE CASCADE,
+	message_id   INTEGER REFERENCES message (ROWID) ON DELETE CASCADE,
+	message_date INTEGER DEFAULT 0,
+	PRIMARY KEY (chat_id, message_id)
+);
+CREATE TABLE attachment (
+	ROWID                         INTEGER PRIMARY KEY AUTOINCREMENT,
+	guid                          TEXT UNIQUE NOT NULL,
+	filename                      TEXT,
+	mime_type                     TEXT,
+	transfer_name                 TEXT,
+	is_sticker                    INTEGER DEFAULT 0,
+	sticker_user_info             BLOB,
+	emoji_image_short_description TEXT
+);
+CREATE TABLE message_attachment_join (
+	message_id    INTEGER REFERENCES message (ROWID) ON DELETE CASCADE,
+	attachment_id INTEGER REFERENCES attachment (ROWID) ON DELETE CASCADE,
+	UNIQUE(message_id, attachment_id)
+);
+`
+
+// ChatDB builds a synthetic chat.db inside a fake macOS home directory, so the macos and connector
+// packages can be exercised on any platform. Failures are reported through the testing.TB.
+type ChatDB struct {
+	t  testing.TB
+	db *sql.DB
+	// The fake home directory, see Paths
+	Home string
+	Path string
+
+	// Messages inserted without an explicit date are placed one second after the previous one
+	clock    time.Time
+	guidSeq  int
+	chatSeq  int
+	handles  map[string]*Handle
+	messages map[string]*InsertedMessage
+}
+
+type Handle struct {
+	RowID   int64
+	ID      string
+	Service string
+}
+
+type Chat struct {
+	db    *ChatDB
+	RowID int64
+	GUID  string
+}
+
+// InsertedMessage identifies a row written to the database.
+type InsertedMessage struct {
+	RowID int64
+	GUID  string
+	Date  time.Time
+}
+
+// NewChatDB creates an empty chat.db at Library/Messages/chat.db in a temporary home directory.
+func NewChatDB(t testing.TB) *ChatDB {
+	t.Helper()
+	home := t.TempDir()
+	path := filepath.Join(home, "Library", "Messages", "chat.db")
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		t.Fatalf("creating chat.db directory: %v", err)
+	}
+	db, err := sql.Open("sqlite3", fmt.Sprintf("file:%s", path))
+	if err != nil {
+		t.Fatalf("opening chat.db: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+	if _, err = db.Exec(chatDBSchema); err != nil {
+		t.Fatalf("creating chat.db schema: %v", err)
+	}
+	return &ChatDB{
+		t:        t,
+		db:       db,
+		Home:     home,
+		Path:     path,
+		clock:    time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC),
+		handles:  make(map[string]*Handle),
+		messages: make(map[string]*InsertedMessage),
+	}
+}
+
+// Paths returns data paths that point the macos clients at this database.
+func (c *ChatDB) Paths() macos.DataPaths {
+	return macos.DataPaths{
+		Home: c.Home,
+	}
+}
+
+// Exec runs raw SQL against the database, for state the builders don't cover.
+func (c *ChatDB) Exec(query string, args ...any) sql.Result {
+	c.t.Helper()
+	result, err := c.db.Exec(query, args...)
+	if err != nil {
+		c.t.Fatalf("executing %q: %v", query, err)
+	}
+	return result
+}
+
+func (c *ChatDB) insert(query string, args ...any) int64 {
+	c.t.Helper()
+	rowID, err := c.Exec(query, args...).LastInsertId()
+	if err != nil {
+		c.t.Fatalf("getting inserted row ID: %v", err)
+	}
+	return rowID
+}
+
+func (c *ChatDB) nextGUID(prefix string) string {
+	c.guidSeq++
+	return fmt.Sprintf("%s-%08X", prefix, c.guidSeq)
+}
+
+// Handle returns the handle for the given phone number or email, creating it on first use.
+func (c *ChatDB) Handle(id string, service string) *Handle {
+	c.t.Helper()
+	key := service + ";" + id
+	if handle, ok := c.handles[key]; ok {
+		return handle
+	}
+	handle := &Handle{
+		RowID:   c.insert(`INSERT INTO handle (id, country, service) VALUES ($1, 'us', $2)`, id, service),
+		ID:      id,
+		Service: service,
+	}
+	c.handles[key] = handle
+	return handle
+}
+
+// DirectChat creates the one-to-one chat with the handle.
+func (c *ChatDB) DirectChat(handle *Handle) *Chat {
+	c.t.Helper()
+	return c.Chat(fmt.Sprintf("%s;-;%s", handle.Service, handle.ID), "", handle)
+}
+
+// GroupChat creates a named group chat with the handles as members.
+func (c *ChatDB) GroupChat(name string, handles ...*Handle) *Chat {
+	c.t.Helper()
+	c.chatSeq++
+	return c.Chat(fmt.Sprintf("iMessage;+;chat%d", c.chatSeq), name, handles...)
+}
+
+func (c *ChatDB) Chat(guid string, displayName string, handles ...*Handle) *Chat {
+	c.t.Helper()
+	chat := &Chat{
+		db:    c,
+		RowID: c.insert(`INSERT INTO chat (guid, display_name, group_id) VALUES ($1, $2, $3)`, guid, displayName, c.nextGUID("group")),
+		GUID:  guid,
+	}
+	for _, handle := range handles {
+		c.Exec(`INSERT INTO chat_handle_join (chat_id, handle_id) VALUES ($1, $2)`, chat.RowID, handle.RowID)
+	}
+	return chat
+}
+
+// Message starts building a message in the chat. Nothing is written until Insert is called.
+func (ch *Chat) Message() *MessageBuilder {
+	return &MessageBuilder{
+		db:       ch.db,
+		chat:     ch,
+		guid:     ch.db.nextGUID("message"),
+		isSent:   true,
+		itemType: macos.ItemTypeMessage,
+	}
+}
+
+// Text is shorthand for a plain text message from the handle, or from us if the handle is nil.
+func (ch *Chat) Text(from *Handle, text string) *InsertedMessage {
+	ch.db.t.Helper()
+	return ch.Message().From(from).Text(text).Insert()
+}
+
+type attachmentRow struct {
+	guid         string
+	path         string
+	mimeType     string
+	transferName string
+	isSticker    bool
+}
+
+// MessageBuilder accumulates the columns of a message row.
+type MessageBuilder struct {
+	db   *ChatDB
+	chat *Chat
+
+	guid               string
+	date               time.Time
+	text               *string
+	subject            *string
+	attributedBody     []byte
+	messageSummaryInfo []byte
+	handle             *Handle
+	otherHandle        *Handle
+	isFromMe           bool
+	isSent             bool
+	isEmote            bool
+	isAudioMessage     bool
+	errorCode          int
+	dateRead           time.Time
+	dateDelivered      time.Time
+	dateEdited         time.Time
+	dateRetracted      time.Time
+	itemType           macos.ItemType
+	groupActionType    macos.GroupActionType
+	groupTitle         *string
+	associatedGUID     *string
+	associatedType     int
+	associatedEmoji    *string
+	balloonBundleID    *string
+	threadGUID         *string
+	threadPart         *string
+	attachments        []attachmentRow
+}
+
+func (b *MessageBuilder) GUID(guid string) *MessageBuilder {
+	b.guid = guid
+	return b
+}
+
+func (b *MessageBuilder) At(date time.Time) *MessageBuilder {
+	b.date = date
+	return b
+}
+
+// From sets the sender. A nil handle makes the message one sent by us.
+func (b *MessageBuilder) From(handle *Handle) *MessageBuilder {
+	b.handle = handle
+	b.isFromMe = handle == nil
+	return b
+}
+
+func (b *MessageBuilder) FromMe() *MessageBuilder {
+	return b.From(nil)
+}
+
+func (b *MessageBuilder) Text(text string) *MessageBuilder {
+	b.text = &text
+	return b
+}
+
+func (b *MessageBuilder) Subject(subject string) *MessageBuilder {
+	b.subject = &subject
+	return b
+}
+
+// AttributedBody sets the typedstream-encoded attributed string, which newer macOS versions store instead of text.
+func (b *MessageBuilder) AttributedBody(attributedBody []byte) *MessageBuilder {
+	b.attributedBody = attributedBody
+	return b
+}
+
+func (b *MessageBuilder) Emote() *MessageBuilder {
+	b.isEmote = true
+	return b
+}
+
+func (b *MessageBuilder) AudioMessage() *MessageBuilder {
+	b.isAudioMessage = true
+	return b
+}
+
+func (b *MessageBuilder) BalloonBundleID(bundleID string) *MessageBuilder {
+	b.balloonBundleID = &bundleID
+	return b
+}
+
+// NotSent leaves is_sent unset, like a message Messages is still sending.
+func (b *MessageBuilder) NotSent() *MessageBuilder {
+	b.isSent = false
+	return b
+}
+
+func (b *MessageBuilder) Failed(errorCode int) *MessageBuilder {
+	b.errorCode = errorCode
+	return b
+}
+
+func (b *MessageBuilder) Delivered(at time.Time) *MessageBuilder {
+	b.dateDelivered = at
+	return b
+}
+
+func (b *MessageBuilder) Read(at time.Time) *MessageBuilder {
+	b.dateRead = at
+	return b
+}
+
+// Edited marks the message as edited. The edit history lives in the message_summary_info plist.
+func (b *MessageBuilder) Edited(at time.Time, messageSummaryInfo []byte) *MessageBuilder {
+	b.dateEdited = at
+	b.messageSummaryInfo = messageSummaryInfo
+	return b
+}
+
+func (b *MessageBuilder) Retracted(at time.Time) *MessageBuilder {
+	b.dateRetracted = at
+	return b
+}
+
+// ReplyTo makes the message an inline reply to a part of another message.
+func (b *MessageBuilder) ReplyTo(target *InsertedMessage, part int) *MessageBuilder {
+	threadPart := fmt.Sprintf("%d:0:0", part)
+	b.threadGUID = &target.GUID
+	b.threadPart = &threadPart
+	return b
+}
+
+// Tapback makes the message a tapback on a part of another message. Use a type from
+// macos.TapbackLove to macos.TapbackEmoji, plus macos.TapbackRemoveOffset to remove one.
+func (b *MessageBuilder) Tapback(target *InsertedMessage, part int, tapbackType macos.TapbackType) *MessageBuilder {
+	associatedGUID := fmt.Sprintf("p:%d/%s", part, target.GUID)
+	b.associatedGUID = &associatedGUID
+	b.associatedType = int(tapbackType)
+	return b
+}
+
+// TapbackEmoji makes the message a custom emoji tapback on a part of another message.
+func (b *MessageBuilder) TapbackEmoji(target *InsertedMessage, part int, emoji string) *MessageBuilder {
+	b.associatedEmoji = &emoji
+	return b.Tapback(target, part, macos.TapbackEmoji)
+}
+
+// GroupAction makes the message a group change, like a member being added or the name being changed.
+// The target handle is the member added or removed, if any.
+func (b *MessageBuilder) GroupAction(itemType macos.ItemType, actionType macos.GroupActionType, target *Handle) *MessageBuilder {
+	b.itemType = itemType
+	b.groupActionType = actionType
+	b.otherHandle = target
+	return b
+}
+
+func (b *MessageBuilder) GroupTitle(title string) *MessageBuilder {
+	b.groupTitle = &title
+	return b
+}
+
+// Attachment adds a file to the message. Paths starting with ~/ are relative to the fake home directory,
+// and the file is created there with the given content if it's not nil.
+func (b *MessageBuilder) Attachment(path string, mimeType string, content []byte) *MessageBuilder {
+	b.db.t.Helper()
+	if content != nil {
+		diskPath, err := b.db.Paths().ResolvePath(path)
+		if err != nil {
+			b.db.t.Fatalf("resolving attachment path %s: %v", path, err)
+		}
+		if err = os.MkdirAll(filepath.Dir(diskPath), 0700); err != nil {
+			b.db.t.Fatalf("creating attachment directory: %v", err)
+		}
+		if err = os.WriteFile(diskPath, content, 0600); err != nil {
+			b.db.t.Fatalf("writing attachment %s: %v", diskPath, err)
+		}
+	}
+	b.attachments = append(b.attachments, attachmentRow{
+		guid:         b.db.nextGUID("attachment"),
+		path:         path,
+		mimeType:     mimeType,
+		transferName: filepath.Base(path),
+	})
+	return b
+}
+
+// Sticker adds a sticker attachment to the message.
+func (b *MessageBuilder) Sticker(path string, mimeType string, content []byte) *MessageBuilder {
+	b.Attachment(path, mimeType, content)
+	b.attachments[len(b.attachments)-1].isSticker = true
+	return b
+}
+
+// Insert writes the message, its chat membership and its attachments.
+func (b *MessageBuilder) Insert() *InsertedMessage {
+	c := b.db
+	c.t.Helper()
+	if b.date.IsZero() {
+		c.clock = c.clock.Add(time.Second)
+		b.date = c.clock
+	} else if b.date.After(c.clock) {
+		c.clock = b.date
+	}
+
+	var handleID, otherHandleID int64
+	service := "iMessage"
+	if b.handle != nil {
+		handleID = b.handle.RowID
+		service = b.handle.Service
+	} else if parts := strings.SplitN(b.chat.GUID, ";", 2); len(parts) == 2 {
+		service = parts[0]
+	}
+	if b.otherHandle != nil {
+		otherHandleID = b.otherHandle.RowID
+	}
+	rowID := c.insert(`
+		INSERT INTO message (
+			guid, text, subject, attributedBody, message_summary_info, handle_id, other_handle, service, error,
+			date, date_read, date_delivered, date_edited, date_retracted,
+			is_delivered, is_from_me, is_read, is_sent, is_emote, is_audio_message,
+			item_type, group_title, group_action_type,
+			associated_message_guid, associated_message_type, associated_message_emoji,
+			balloon_bundle_id, thread_originator_guid, thread_originator_part
+		) VALUES (
+			$1, $2, $3, $4, $5, $6, $7, $8, $9,
+			$10, $11, $12, $13, $14,
+			$15, $16, $17, $18, $19, $20,
+			$21, $22, $23,
+			$24, $25, $26,
+			$27, $28, $29
+		)`,
+		b.guid, b.text, b.subject, b.attributedBody, b.messageSummaryInfo, handleID, otherHandleID, service, b.errorCode,
+		appleDate(b.date), appleDate(b.dateRead), appleDate(b.dateDelivered), appleDate(b.dateEdited), appleDate(b.dateRetracted),
+		!b.dateDelivered.IsZero(), b.isFromMe, !b.dateRead.IsZero(), b.isSent, b.isEmote, b.isAudioMessage,
+		b.itemType, b.groupTitle, b.groupActionType,
+		b.associatedGUID, b.associatedType, b.associatedEmoji,
+		b.balloonBundleID, b.threadGUID, b.threadPart,
+	)
+	c.Exec(`INSERT INTO chat_message_join (chat_id, message_id, message_date) VALUES ($1, $2, $3)`, b.chat.RowID, rowID, appleDate(b.date))
+	for _, attachment := range b.attachments {
+		attachmentRowID := c.insert(`
+			INSERT INTO attachment (guid, filename, mime_type, transfer_name, is_sticker)
+			VALUES ($1, $2, $3, $4, $5)`,
+			attachment.guid, attachment.path, attachment.mimeType, attachment.transferName, attachment.isSticker,
+		)
+		c.Exec(`INSERT INTO message_attachment_join (message_id, attachment_id) VALUES ($1, $2)`, rowID, attachmentRowID)
+	}
+
+	inserted := &InsertedMessage{
+		RowID: rowID,
+		GUID:  b.guid,
+		Date:  b.date,
+	}
+	c.messages[b.guid] = inserted
+	return inserted
+}
+
+// SetDelivered updates an existing message like Messages does once the recipient's device acknowledges it.
+func (c *ChatDB) SetDelivered(message *InsertedMessage, at time.Time) {
+	c.t.Helper()
+	c.Exec(`UPDATE message SET is_sent=1, is_delivered=1, date_delivered=$1 WHERE ROWID=$2`, appleDate(at), message.RowID)
+}
+
+// SetFailed updates an existing message like Messages does when sending it fails.
+func (c *ChatDB) SetFailed(message *InsertedMessage, errorCode int) {
+	c.t.Helper()
+	c.Exec(`UPDATE message SET error=$1 WHERE ROWID=$2`, errorCode, message.RowID)
+}
+
+// SetRead updates an existing message like Messages does when it's read.
+func (c *ChatDB) SetRead(message *InsertedMessage, at time.Time) {
+	c.t.Helper()
+	c.Exec(`UPDATE message SET is_read=1, date_read=$1 WHERE ROWID=$2`, appleDate(at), message.RowID)
+}
+
+// SetEdited updates an existing message like Messages does when it's edited or has a part unsent.
+func (c *ChatDB) SetEdited(message *InsertedMessage, at time.Time, text string, attributedBody []byte, messageSummaryInfo []byte) {
+	c.t.Helper()
+	c.Exec(`UPDATE message SET date_edited=$1, text=$2, attributedBody=$3, message_summary_info=$4 WHERE ROWID=$5`,
+		appleDate(at), text, attributedBody, messageSummaryInfo, message.RowID)
+}
+
+// SetRetracted updates an existing message like Messages does when the whole message is unsent.
+func (c *ChatDB) SetRetracted(message *InsertedMessage, at time.Time) {
+	c.t.Helper()
+	c.Exec(`UPDATE message SET date_retracted=$1, text=NULL, attributedBody=NULL WHERE ROWID=$2`, appleDate(at), message.RowID)
+}
+
+// GetMessage returns a message inserted earlier by its GUID.
+func (c *ChatDB) GetMessage(guid string) *InsertedMessage {
+	return c.messages[guid]
+}
+
+func appleDate(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano() - macos.AppleEpochUnixNano
+}
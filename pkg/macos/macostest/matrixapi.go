@@ -0,0 +1,481 @@
+package macostest
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/bridgev2"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+const defaultFakeMXID = id.UserID("@bridgebot:example.com")
+
+var ErrMediaNotFound = fmt.Errorf("%w: media not found", mautrix.MNotFound)
+
+type UploadedMedia struct {
+	URI      id.ContentURIString
+	RoomID   id.RoomID
+	FileName string
+	MimeType string
+	Data     []byte
+}
+
+type SentMessage struct {
+	RoomID  id.RoomID
+	EventID id.EventID
+	Type    event.Type
+	Content *event.Content
+	Extra   *bridgev2.MatrixSendExtra
+}
+
+type SentState struct {
+	RoomID    id.RoomID
+	EventID   id.EventID
+	Type      event.Type
+	StateKey  string
+	Content   *event.Content
+	Timestamp time.Time
+}
+
+type ReadMarker struct {
+	RoomID    id.RoomID
+	EventID   id.EventID
+	Timestamp time.Time
+}
+
+// FakeMatrixAPI is an in-memory bridgev2.MatrixAPI that records everything sent through it.
+// Uploaded media is kept under fake mxc:// URIs and can be downloaded again.
+type FakeMatrixAPI struct {
+	MXID         id.UserID
+	DoublePuppet bool
+
+	lock     sync.Mutex
+	seq      int
+	media    map[id.ContentURIString]*UploadedMedia
+	uploads  []*UploadedMedia
+	messages []SentMessage
+	state    []SentState
+	reads    []ReadMarker
+	rooms    map[id.RoomID]*mautrix.ReqCreateRoom
+	joined   map[id.RoomID]bool
+	invited  map[id.RoomID][]id.UserID
+	unread   map[id.RoomID]bool
+	typing   map[id.RoomID]bridgev2.TypingType
+	tags     map[id.RoomID]map[event.RoomTag]bool
+	mutes    map[id.RoomID]time.Time
+
+	displayName  string
+	avatarURL    id.ContentURIString
+	profileExtra any
+}
+
+var _ bridgev2.MatrixAPI = (*FakeMatrixAPI)(nil)
+
+func NewFakeMatrixAPI(mxid id.UserID) *FakeMatrixAPI {
+	if mxid == "" {
+		mxid = defaultFakeMXID
+	}
+	return &FakeMatrixAPI{
+		MXID:    mxid,
+		media:   make(map[id.ContentURIString]*UploadedMedia),
+		rooms:   make(map[id.RoomID]*mautrix.ReqCreateRoom),
+		joined:  make(map[id.RoomID]bool),
+		invited: make(map[id.RoomID][]id.UserID),
+		unread:  make(map[id.RoomID]bool),
+		typing:  make(map[id.RoomID]bridgev2.TypingType),
+		tags:    make(map[id.RoomID]map[event.RoomTag]bool),
+		mutes:   make(map[id.RoomID]time.Time),
+	}
+}
+
+// next returns a new unique local part, must be called with the lock held.
+func (f *FakeMatrixAPI) next() string {
+	f.seq++
+	return fmt.Sprintf("fake%d", f.seq)
+}
+
+func (f *FakeMatrixAPI) serverName() string {
+	_, server, err := f.MXID.Parse()
+	if err != nil || server == "" {
+		return "example.com"
+	}
+	return server
+}
+
+func (f *FakeMatrixAPI) GetMXID() id.UserID {
+	return f.MXID
+}
+
+func (f *FakeMatrixAPI) IsDoublePuppet() bool {
+	return f.DoublePuppet
+}
+
+func (f *FakeMatrixAPI) SendMessage(ctx context.Context, roomID id.RoomID, eventType event.Type, content *event.Content, extra *bridgev2.MatrixSendExtra) (*mautrix.RespSendEvent, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	eventID := id.EventID(fmt.Sprintf("$%s:%s", f.next(), f.serverName()))
+	f.messages = append(f.messages, SentMessage{
+		RoomID:  roomID,
+		EventID: eventID,
+		Type:    eventType,
+		Content: content,
+		Extra:   extra,
+	})
+	return &mautrix.RespSendEvent{EventID: eventID}, nil
+}
+
+func (f *FakeMatrixAPI) SendState(ctx context.Context, roomID id.RoomID, eventType event.Type, stateKey string, content *event.Content, ts time.Time) (*mautrix.RespSendEvent, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	eventID := id.EventID(fmt.Sprintf("$%s:%s", f.next(), f.serverName()))
+	f.state = append(f.state, SentState{
+		RoomID:    roomID,
+		EventID:   eventID,
+		Type:      eventType,
+		StateKey:  stateKey,
+		Content:   content,
+		Timestamp: ts,
+	})
+	return &mautrix.RespSendEvent{EventID: eventID}, nil
+}
+
+func (f *FakeMatrixAPI) MarkRead(ctx context.Context, roomID id.RoomID, eventID id.EventID, ts time.Time) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.reads = append(f.reads, ReadMarker{
+		RoomID:    roomID,
+		EventID:   eventID,
+		Timestamp: ts,
+	})
+	return nil
+}
+
+func (f *FakeMatrixAPI) MarkUnread(ctx context.Context, roomID id.RoomID, unread bool) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.unread[roomID] = unread
+	return nil
+}
+
+func (f *FakeMatrixAPI) MarkTyping(ctx context.Context, roomID id.RoomID, typingType bridgev2.TypingType, timeout time.Duration) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if timeout == 0 {
+		delete(f.typing, roomID)
+	} else {
+		f.typing[roomID] = typingType
+	}
+	return nil
+}
+
+func (f *FakeMatrixAPI) DownloadMedia(ctx context.Context, uri id.ContentURIString, file *event.EncryptedFileInfo) ([]byte, error) {
+	if file != nil {
+		uri = file.URL
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	media, ok := f.media[uri]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrMediaNotFound, uri)
+	}
+	return slices.Clone(media.Data), nil
+}
+
+func (f *FakeMatrixAPI) DownloadMediaToFile(ctx context.Context, uri id.ContentURIString, file *event.EncryptedFileInfo, writable bool, callback func(*os.File) error) error {
+	data, err := f.DownloadMedia(ctx, uri, file)
+	if err != nil {
+		return err
+	}
+	tempFile, err := os.CreateTemp("", "fake-matrix-download-*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tempFile.Close()
+		_ = os.Remove(tempFile.Name())
+	}()
+	if _, err = tempFile.Write(data); err != nil {
+		return err
+	}
+	if _, err = tempFile.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	return callback(tempFile)
+}
+
+func (f *FakeMatrixAPI) UploadMedia(ctx context.Context, roomID id.RoomID, data []byte, fileName, mimeType string) (id.ContentURIString, *event.EncryptedFileInfo, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	media := &UploadedMedia{
+		URI:      id.ContentURIString(fmt.Sprintf("mxc://%s/%s", f.serverName(), f.next())),
+		RoomID:   roomID,
+		FileName: fileName,
+		MimeType: mimeType,
+		Data:     slices.Clone(data),
+	}
+	f.media[media.URI] = media
+	f.uploads = append(f.uploads, media)
+	return media.URI, nil, nil
+}
+
+// UploadMediaStream runs the callback against a temporary file if requireFile is set, or an in-memory buffer otherwise,
+// and uploads whatever it wrote.
+func (f *FakeMatrixAPI) UploadMediaStream(ctx context.Context, roomID id.RoomID, size int64, requireFile bool, cb bridgev2.FileStreamCallback) (id.ContentURIString, *event.EncryptedFileInfo, error) {
+	if !requireFile {
+		var buffer bytes.Buffer
+		result, err := cb(&buffer)
+		if err != nil {
+			return "", nil, err
+		}
+		return f.UploadMedia(ctx, roomID, buffer.Bytes(), result.FileName, result.MimeType)
+	}
+
+	tempFile, err := os.CreateTemp("", "fake-matrix-upload-*")
+	if err != nil {
+		return "", nil, err
+	}
+	defer func() {
+		_ = tempFile.Close()
+		_ = os.Remove(tempFile.Name())
+	}()
+	result, err := cb(tempFile)
+	if err != nil {
+		return "", nil, err
+	}
+	path := tempFile.Name()
+	if result.ReplacementFile != "" {
+		path = result.ReplacementFile
+		defer os.Remove(result.ReplacementFile)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", nil, err
+	}
+	return f.UploadMedia(ctx, roomID, data, result.FileName, result.MimeType)
+}
+
+func (f *FakeMatrixAPI) SetDisplayName(ctx context.Context, name string) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.displayName = name
+	return nil
+}
+
+func (f *FakeMatrixAPI) SetAvatarURL(ctx context.Context, avatarURL id.ContentURIString) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.avatarURL = avatarURL
+	return nil
+}
+
+func (f *FakeMatrixAPI) SetExtraProfileMeta(ctx context.Context, data any) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.profileExtra = data
+	return nil
+}
+
+func (f *FakeMatrixAPI) CreateRoom(ctx context.Context, req *mautrix.ReqCreateRoom) (id.RoomID, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	roomID := id.RoomID(fmt.Sprintf("!%s:%s", f.next(), f.serverName()))
+	f.rooms[roomID] = req
+	f.joined[roomID] = true
+	if req != nil {
+		f.invited[roomID] = slices.Clone(req.Invite)
+	}
+	return roomID, nil
+}
+
+func (f *FakeMatrixAPI) DeleteRoom(ctx context.Context, roomID id.RoomID, puppetsOnly bool) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	delete(f.rooms, roomID)
+	delete(f.joined, roomID)
+	delete(f.invited, roomID)
+	return nil
+}
+
+func (f *FakeMatrixAPI) EnsureJoined(ctx context.Context, roomID id.RoomID) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.joined[roomID] = true
+	return nil
+}
+
+func (f *FakeMatrixAPI) EnsureInvited(ctx context.Context, roomID id.RoomID, userID id.UserID) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if !slices.Contains(f.invited[roomID], userID) {
+		f.invited[roomID] = append(f.invited[roomID], userID)
+	}
+	return nil
+}
+
+func (f *FakeMatrixAPI) TagRoom(ctx context.Context, roomID id.RoomID, tag event.RoomTag, isTagged bool) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.tags[roomID] == nil {
+		f.tags[roomID] = make(map[event.RoomTag]bool)
+	}
+	f.tags[roomID][tag] = isTagged
+	return nil
+}
+
+func (f *FakeMatrixAPI) MuteRoom(ctx context.Context, roomID id.RoomID, until time.Time) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.mutes[roomID] = until
+	return nil
+}
+
+// Uploads returns all media uploaded so far, in order.
+func (f *FakeMatrixAPI) Uploads() []*UploadedMedia {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return slices.Clone(f.uploads)
+}
+
+// Media returns the media uploaded under the given URI, or nil.
+func (f *FakeMatrixAPI) Media(uri id.ContentURIString) *UploadedMedia {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.media[uri]
+}
+
+// PutMedia stores data under a new URI as if someone else had uploaded it, for use as the source of Matrix media.
+func (f *FakeMatrixAPI) PutMedia(data []byte, fileName, mimeType string) id.ContentURIString {
+	uri, _, _ := f.UploadMedia(context.Background(), "", data, fileName, mimeType)
+	return uri
+}
+
+// Messages returns all messages sent so far, in order.
+func (f *FakeMatrixAPI) Messages() []SentMessage {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return slices.Clone(f.messages)
+}
+
+// MessagesIn returns the messages sent to the given room, in order.
+func (f *FakeMatrixAPI) MessagesIn(roomID id.RoomID) []SentMessage {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	var messages []SentMessage
+	for _, message := range f.messages {
+		if message.RoomID == roomID {
+			messages = append(messages, message)
+		}
+	}
+	return messages
+}
+
+// State returns all state events sent so far, in order.
+func (f *FakeMatrixAPI) State() []SentState {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return slices.Clone(f.state)
+}
+
+// CurrentState returns the latest state event of the given type and state key in the room, or nil.
+func (f *FakeMatrixAPI) CurrentState(roomID id.RoomID, eventType event.Type, stateKey string) *SentState {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	for i := len(f.state) - 1; i >= 0; i-- {
+		state := f.state[i]
+		if state.RoomID == roomID && state.Type == eventType && state.StateKey == stateKey {
+			return &state
+		}
+	}
+	return nil
+}
+
+// ReadMarkers returns all read markers set so far, in order.
+func (f *FakeMatrixAPI) ReadMarkers() []ReadMarker {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return slices.Clone(f.reads)
+}
+
+// Room returns the request a room was created with, or nil if it wasn't created through the fake.
+func (f *FakeMatrixAPI) Room(roomID id.RoomID) *mautrix.ReqCreateRoom {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.rooms[roomID]
+}
+
+func (f *FakeMatrixAPI) IsJoined(roomID id.RoomID) bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.joined[roomID]
+}
+
+func (f *FakeMatrixAPI) Invited(roomID id.RoomID) []id.UserID {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return slices.Clone(f.invited[roomID])
+}
+
+func (f *FakeMatrixAPI) IsUnread(roomID id.RoomID) bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.unread[roomID]
+}
+
+// Typing returns the current typing state in the room, and whether there is one.
+func (f *FakeMatrixAPI) Typing(roomID id.RoomID) (bridgev2.TypingType, bool) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	typingType, ok := f.typing[roomID]
+	return typingType, ok
+}
+
+func (f *FakeMatrixAPI) IsTagged(roomID id.RoomID, tag event.RoomTag) bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.tags[roomID][tag]
+}
+
+// MutedUntil returns when the mute on the room ends, or the zero time if it was never muted.
+func (f *FakeMatrixAPI) MutedUntil(roomID id.RoomID) time.Time {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.mutes[roomID]
+}
+
+// Profile returns the display name, avatar and extra metadata last set on the fake's user.
+func (f *FakeMatrixAPI) Profile() (string, id.ContentURIString, any) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.displayName, f.avatarURL, f.profileExtra
+}
+
+// Reset forgets everything recorded, including uploaded media.
+func (f *FakeMatrixAPI) Reset() {
+	fresh := NewFakeMatrixAPI(f.MXID)
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.seq = 0
+	f.media = fresh.media
+	f.uploads = nil
+	f.messages = nil
+	f.state = nil
+	f.reads = nil
+	f.rooms = fresh.rooms
+	f.joined = fresh.joined
+	f.invited = fresh.invited
+	f.unread = fresh.unread
+	f.typing = fresh.typing
+	f.tags = fresh.tags
+	f.mutes = fresh.mutes
+	f.displayName = ""
+	f.avatarURL = ""
+	f.profileExtra = nil
+}
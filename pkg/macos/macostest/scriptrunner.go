@@ -0,0 +1,105 @@
+// Package macostest provides fakes and fixtures of the macos package's external dependencies for use in tests.
+package macostest
+
+import (
+	"slices"
+	"sync"
+
+	"github.com/GroveJay/matrix-macOS-Messages-bridge/pkg/macos"
+)
+
+type ScriptCall struct {
+	Script string
+	Args   []string
+}
+
+type ScriptResult struct {
+	Stdout string
+	Stderr string
+	Err    error
+}
+
+// FakeScriptRunner is a macos.ScriptRunner that records every call and returns scripted results
+// instead of running osascript.
+type FakeScriptRunner struct {
+	lock    sync.Mutex
+	calls   []ScriptCall
+	results map[string][]ScriptResult
+	// Returned for scripts with no queued results
+	Default ScriptResult
+	// If set, called for scripts with no queued results instead of returning Default
+	Handler func(script string, args ...string) (string, string, error)
+}
+
+var _ macos.ScriptRunner = (*FakeScriptRunner)(nil)
+
+func NewFakeScriptRunner() *FakeScriptRunner {
+	return &FakeScriptRunner{
+		results: make(map[string][]ScriptResult),
+	}
+}
+
+// On queues a result for the next run of the given script. Results queued for the same script
+// are returned in order, and the last one is repeated once the queue is drained.
+func (f *FakeScriptRunner) On(script string, stdout string, stderr string, err error) *FakeScriptRunner {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.results[script] = append(f.results[script], ScriptResult{
+		Stdout: stdout,
+		Stderr: stderr,
+		Err:    err,
+	})
+	return f
+}
+
+func (f *FakeScriptRunner) RunScript(script string, args ...string) (string, string, error) {
+	f.lock.Lock()
+	f.calls = append(f.calls, ScriptCall{
+		Script: script,
+		Args:   slices.Clone(args),
+	})
+	queued := f.results[script]
+	var result *ScriptResult
+	if len(queued) > 0 {
+		result = &queued[0]
+		if len(queued) > 1 {
+			f.results[script] = queued[1:]
+		}
+	}
+	handler := f.Handler
+	f.lock.Unlock()
+
+	if result != nil {
+		return result.Stdout, result.Stderr, result.Err
+	} else if handler != nil {
+		return handler(script, args...)
+	}
+	return f.Default.Stdout, f.Default.Stderr, f.Default.Err
+}
+
+// Calls returns every call made so far, in order.
+func (f *FakeScriptRunner) Calls() []ScriptCall {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return slices.Clone(f.calls)
+}
+
+// CallsFor returns the calls made with the given script, in order.
+func (f *FakeScriptRunner) CallsFor(script string) []ScriptCall {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	var calls []ScriptCall
+	for _, call := range f.calls {
+		if call.Script == script {
+			calls = append(calls, call)
+		}
+	}
+	return calls
+}
+
+func (f *FakeScriptRunner) Reset() {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.calls = nil
+	f.results = make(map[string][]ScriptResult)
+}
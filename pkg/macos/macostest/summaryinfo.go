@@ -0,0 +1,74 @@
+package macostest
+
+import (
+	"strconv"
+	"time"
+	"unicode/utf16"
+
+	"github.com/GroveJay/matrix-macOS-Messages-bridge/pkg/macos"
+	"howett.net/plist"
+)
+
+// SummaryInfo builds the message_summary_info plist that records the edits and unsent parts of a message.
+type SummaryInfo struct {
+	parts  int
+	edits  map[string][]map[string]any
+	unsent []uint64
+}
+
+// NewSummaryInfo starts a summary for a message with the given number of parts, none of which are changed.
+func NewSummaryInfo(parts int) *SummaryInfo {
+	return &SummaryInfo{
+		parts: parts,
+		edits: make(map[string][]map[string]any),
+	}
+}
+
+// Edit records an edit of a part to the given text. The first edit of a part should repeat its original text,
+// as Messages keeps the original as the first entry of the history.
+func (s *SummaryInfo) Edit(part int, at time.Time, text string) *SummaryInfo {
+	attributedBody, err := macos.EncodeAttributedString(&macos.AttributedString{
+		String: text,
+		Runs: []macos.AttributeRun{{
+			Length:     len(utf16.Encode([]rune(text))),
+			Attributes: macos.AttributeDictionary{{Key: string(macos.MessagePartAttributeName), Value: part}},
+		}},
+	})
+	if err != nil {
+		panic(err)
+	}
+	key := strconv.Itoa(part)
+	s.edits[key] = append(s.edits[key], map[string]any{
+		"d": float64(at.Unix() - macos.AppleEpochUnix),
+		"t": attributedBody,
+	})
+	return s
+}
+
+// Unsend records that a part was unsent.
+func (s *SummaryInfo) Unsend(part int) *SummaryInfo {
+	s.unsent = append(s.unsent, uint64(part))
+	return s
+}
+
+// Bytes returns the summary as a binary plist, as stored in chat.db.
+func (s *SummaryInfo) Bytes() []byte {
+	originalTextRanges := make(map[string]any, s.parts)
+	for part := range s.parts {
+		originalTextRanges[strconv.Itoa(part)] = map[string]any{"lo": 0, "le": 0}
+	}
+	summary := map[string]any{
+		"otr": originalTextRanges,
+	}
+	if len(s.edits) > 0 {
+		summary["ec"] = s.edits
+	}
+	if len(s.unsent) > 0 {
+		summary["rp"] = s.unsent
+	}
+	data, err := plist.Marshal(summary, plist.BinaryFormat)
+	if err != nil {
+		panic(err)
+	}
+	return data
+}
@@ -1,11 +1,13 @@
 package macos
 
 import (
+	"cmp"
 	"context"
 	"errors"
 	"fmt"
-	"html/template"
+	"html"
 	"os"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -24,6 +26,9 @@ type Message struct {
 	DateRead      int64
 	DateEdited    int64
 	DateRetracted int64
+	DateDelivered int64
+	// Non-zero if Messages failed to send the message
+	Error int
 
 	IsSent         bool
 	IsFromMe       bool
@@ -54,9 +59,10 @@ type Message struct {
 	ReadAt      time.Time
 	EditedAt    time.Time
 	RetractedAt time.Time
+	DeliveredAt time.Time
 
 	Attachments        []*Attachment
-	Components         []Archivable
+	AttributedBody     *AttributedString
 	CombinedComponents []CombinedComponent
 	EditedMessageParts []*EditedMessagePart
 
@@ -84,14 +90,15 @@ ParsediMessage:
 // TODO: Comb through CombinedComponent for TextEffects of TextEffectMention and add in the userIDs?
 ConvertMessageToParts
 - Message.ConvertAttributesToMessagePart
-  - FormatTextRangeEffectsOnText
-    - TextEffect.ApplyTextRangeEffectToText
+  - RenderTextRangeEffects
 */
 
 func (m Message) String() string {
 	result := fmt.Sprintf("Row: %d\nSubject: %s\nText: %s\nAttributedBodyText: %s", m.RowID, m.Subject, m.Text, m.AttributedBodyText)
 	result += fmt.Sprintf("\nAttachments: %d", len(m.Attachments))
-	result += fmt.Sprintf("\nComponents: %d", len(m.Components))
+	if m.AttributedBody != nil {
+		result += fmt.Sprintf("\nAttributeRuns: %d", len(m.AttributedBody.Runs))
+	}
 	result += fmt.Sprintf("\nCombinedComponents: %d", len(m.CombinedComponents))
 	result += fmt.Sprintf("\nEditedMessageParts: %d", len(m.EditedMessageParts))
 	if len(m.EditedMessageParts) > 0 {
@@ -99,7 +106,11 @@ func (m Message) String() string {
 			result += fmt.Sprintf("\n\t%s - %d edits", editedMessagePart.Status, len(editedMessagePart.EditHistory))
 			if len(editedMessagePart.EditHistory) > 0 {
 				for _, editHistory := range editedMessagePart.EditHistory {
-					result += fmt.Sprintf("\n\t\t (%d) - %s", len(editHistory.Components), *editHistory.Text)
+					runs := 0
+					if editHistory.AttributedBody != nil {
+						runs = len(editHistory.AttributedBody.Runs)
+					}
+					result += fmt.Sprintf("\n\t\t (%d) - %s", runs, *editHistory.Text)
 				}
 			}
 		}
@@ -110,6 +121,57 @@ func (m Message) String() string {
 	return result
 }
 
+type ChatSummary struct {
+	GUID          string
+	LastMessageAt time.Time
+}
+
+// MessageCursor is a position in chat.db. Messages are ordered by date, with the ROWID breaking ties.
+type MessageCursor struct {
+	Date  int64 `json:"date"`
+	RowID int   `json:"row_id"`
+}
+
+// After returns whether the cursor is past the other one.
+func (c MessageCursor) After(other MessageCursor) bool {
+	return c.Date > other.Date || (c.Date == other.Date && c.RowID > other.RowID)
+}
+
+func (m *Message) GetCursor() MessageCursor {
+	return MessageCursor{
+		Date:  m.Date,
+		RowID: m.RowID,
+	}
+}
+
+// GetIngestCursor returns the position of the latest change to the message,
+// as edits and retractions only update date_edited and date_retracted.
+func (m *Message) GetIngestCursor() MessageCursor {
+	return MessageCursor{
+		Date:  max(m.Date, m.DateEdited, m.DateRetracted),
+		RowID: m.RowID,
+	}
+}
+
+// OutgoingStatus is the send state of a message sent by us, which changes without the message's dates changing.
+type OutgoingStatus struct {
+	GUID        string
+	IsSent      bool
+	IsDelivered bool
+	DeliveredAt time.Time
+	Error       int
+}
+
+func (m *Message) GetOutgoingStatus() *OutgoingStatus {
+	return &OutgoingStatus{
+		GUID:        m.GUID,
+		IsSent:      m.IsSent,
+		IsDelivered: m.IsDelivered,
+		DeliveredAt: m.DeliveredAt,
+		Error:       m.Error,
+	}
+}
+
 type TapbackType int
 
 const (
@@ -133,6 +195,11 @@ type Tapback struct {
 	Emoji      string
 }
 
+// GetTargetPartID returns the ID of the part the tapback is on, numbered like ConvertMessageToParts numbers them.
+func (t *Tapback) GetTargetPartID() networkid.PartID {
+	return networkid.PartID(strconv.Itoa(t.TargetPart))
+}
+
 var (
 	ErrUnknownNormalTapbackTarget = errors.New("unrecognized formatting of normal tapback target")
 	ErrInvalidTapbackTargetPart   = errors.New("tapback target part index is invalid")
@@ -207,11 +274,7 @@ type Attachment struct {
 	EmojiImageShortDescription string
 }
 
-func (a Attachment) Read() (result []byte, err error) {
-	a.PathOnDisk, err = ReplaceHomeDirectory(a.PathOnDisk)
-	if err != nil {
-		return nil, fmt.Errorf("reading attachment: %w", err)
-	}
+func (a Attachment) Read() ([]byte, error) {
 	return os.ReadFile(a.PathOnDisk)
 }
 
@@ -269,102 +332,160 @@ func (m *Message) IsPartEdited(index int) bool {
 		m.EditedMessageParts[index].Status == EditedMessageStatusEdited
 }
 
-func (m *Message) ConvertAttributesToMessagePart(attributes []TextRangeEffect, index int) *bridgev2.ConvertedMessagePart {
+// MentionResolver returns the Matrix user that a handle mentioned in a message belongs to, or an empty ID if it's unknown.
+type MentionResolver func(handle string) id.UserID
+
+// resolveMentions returns the effects with the Matrix user of each mention filled in, along with who was mentioned.
+func resolveMentions(textRangeEffects []TextRangeEffect, resolveMention MentionResolver) ([]TextRangeEffect, *event.Mentions) {
+	mentions := &event.Mentions{}
+	if resolveMention == nil {
+		return textRangeEffects, mentions
+	}
+	resolved := slices.Clone(textRangeEffects)
+	for index, textRangeEffect := range resolved {
+		if mention, ok := textRangeEffect.TextEffect.(TextEffectMention); ok {
+			mention.UserID = resolveMention(mention.Mention)
+			if mention.UserID != "" {
+				mentions.Add(mention.UserID)
+			}
+			resolved[index].TextEffect = mention
+		}
+	}
+	return resolved, mentions
+}
+
+func (m *Message) ConvertAttributesToMessagePart(attributes []TextRangeEffect, index int, resolveMention MentionResolver) *bridgev2.ConvertedMessagePart {
 	if m.IsPartEdited(index) {
 		if len(m.EditedMessageParts) > 0 {
-			return m.ConvertEditedMessagePart(index)
+			return m.ConvertEditedMessagePart(index, resolveMention)
 		}
 	} else {
 		convertedMessagePart := &bridgev2.ConvertedMessagePart{
-			Type:    event.EventMessage,
-			Content: &event.MessageEventContent{},
+			Type: event.EventMessage,
+			Content: &event.MessageEventContent{
+				MsgType: event.MsgText,
+			},
 		}
-		formattedText := FormatTextRangeEffectsOnText(m.AttributedBodyText, attributes)
-		// If we failed to parse any text above, make sure we sanitize if before using it
-		if formattedText == "" {
-			formattedText = template.HTMLEscapeString(m.AttributedBodyText)
+		attributes, convertedMessagePart.Content.Mentions = resolveMentions(attributes, resolveMention)
+		body, formattedBody := RenderTextRangeEffects(m.AttributedBodyText, attributes)
+		// If we failed to parse any text above, fall back to the whole text
+		if body == "" {
+			body = m.AttributedBodyText
 		}
 
-		if strings.HasPrefix(formattedText, FITNESS_RECEIVER) {
-			formattedText = strings.Replace(formattedText, FITNESS_RECEIVER, "", 1)
+		convertedMessagePart.Content.Body = strings.TrimPrefix(body, FITNESS_RECEIVER)
+		if formattedBody != "" {
+			convertedMessagePart.Content.Format = event.FormatHTML
+			convertedMessagePart.Content.FormattedBody = strings.TrimPrefix(formattedBody, FITNESS_RECEIVER)
 		}
 
-		convertedMessagePart.Content.Format = event.FormatHTML
-		convertedMessagePart.Content.FormattedBody = formattedText
-
 		return convertedMessagePart
 	}
 	return nil
 }
 
-func (m *Message) ConvertMessageToParts(ctx context.Context, intent bridgev2.MatrixAPI, roomId id.RoomID) ([]*bridgev2.ConvertedMessagePart, error) {
+func (m *Message) ConvertMessageToParts(ctx context.Context, intent bridgev2.MatrixAPI, roomId id.RoomID, resolveMention MentionResolver) ([]*bridgev2.ConvertedMessagePart, error) {
 	parts := []*bridgev2.ConvertedMessagePart{}
-	if m.ItemType == 6 {
-		parts = append(parts, ErrorToMessagePart(errors.New("unsupported item type (6: Shareplay)")))
-		return parts, nil
-	}
-	if m.ItemType == 4 {
-		parts = append(parts, ErrorToMessagePart(errors.New("unsupported item type (4: Location Sharing)")))
-		return parts, nil
+	var onlyPart *bridgev2.ConvertedMessagePart
+	switch {
+	case m.ItemType == 6:
+		onlyPart = ErrorToMessagePart(errors.New("unsupported item type (6: Shareplay)"))
+	case m.ItemType == 4:
+		onlyPart = ErrorToMessagePart(errors.New("unsupported item type (4: Location Sharing)"))
+	case m.BalloonBundleID != "":
+		onlyPart = m.ConvertAppMessageToMessagePart()
 	}
-	if m.BalloonBundleID != "" {
-		parts = append(parts, m.ConvertAppMessageToMessagePart())
-		return parts, nil
+	if onlyPart != nil {
+		onlyPart.ID = networkid.PartID("0")
+		return append(parts, onlyPart), nil
 	}
 
 	attachmentIndex := 0
 	for componentIndex, combinedComponent := range m.CombinedComponents {
+		var part *bridgev2.ConvertedMessagePart
 		switch component := combinedComponent.(type) {
 		case CombinedComponentAttachment:
 			if attachmentIndex < len(m.Attachments) {
 				attachment := m.Attachments[attachmentIndex]
-				convertedAttachment := attachment.ConvertAttachmentToConvertedMessagePart(ctx, intent, roomId, &component.AttachmentMeta)
+				attachmentIndex++
+				part = attachment.ConvertAttachmentToConvertedMessagePart(ctx, intent, roomId, &component.AttachmentMeta)
 				if attachment.IsSticker != 0 {
 					// Could do "more" here: https://github.com/ReagentX/imessage-exporter/blob/develop/imessage-exporter/src/exporters/html.rs#L626
 					switch attachment.StickerSource {
 					case StickerSourceGenmoji:
 						if attachment.EmojiImageShortDescription != "" {
-							convertedAttachment.Content.Body += fmt.Sprintf(" [Genmoji prompt: %s]", attachment.EmojiImageShortDescription)
+							part.Content.Body += fmt.Sprintf(" [Genmoji prompt: %s]", attachment.EmojiImageShortDescription)
 						}
 					case StickerSourceAnimoji, StickerSourceAnimojiJellyfish:
-						convertedAttachment.Content.Body += " [Animoji from Memoji]"
+						part.Content.Body += " [Animoji from Memoji]"
 					case StickerSourceUserGenerated:
 					case StickerSourceNone:
 					}
 				}
-				parts = append(parts, convertedAttachment)
 			} else {
-				parts = append(parts, ErrorToMessagePart(errors.New("attachment does not exist")))
+				part = ErrorToMessagePart(errors.New("attachment does not exist"))
 			}
 		case CombinedComponentText:
 			if len(m.AttributedBodyText) > 0 {
-				if convertedMessage := m.ConvertAttributesToMessagePart(component.TextRangeEffects, componentIndex); convertedMessage != nil {
-					parts = append(parts, convertedMessage)
-				}
+				part = m.ConvertAttributesToMessagePart(component.TextRangeEffects, componentIndex, resolveMention)
 			}
 		case CombinedComponentRetraction:
 			if len(m.EditedMessageParts) != 0 {
-				if convertedEditedMessagePart := m.ConvertEditedMessagePart(componentIndex); convertedEditedMessagePart != nil {
-					parts = append(parts, convertedEditedMessagePart)
-				}
+				part = m.ConvertEditedMessagePart(componentIndex, resolveMention)
 			}
 		default:
 			panic(fmt.Sprintf("invalid type: %T", component))
 		}
+		if part != nil {
+			// Parts are numbered by their index in the message, which is what replies and edits refer to
+			part.ID = networkid.PartID(strconv.Itoa(componentIndex))
+			parts = append(parts, part)
+		}
 	}
 
 	if len(parts) == 0 {
 		// If no other combined components produced parts, add message text as a part
 		if textPart := m.ConvertMessageText(); textPart != nil {
+			textPart.ID = networkid.PartID("0")
 			parts = append(parts, textPart)
 		}
 	}
 
-	for i, part := range parts {
-		part.ID = networkid.PartID(strconv.Itoa(i))
+	return parts, nil
+}
+
+// PartIndexes returns the index in the message of each part ConvertMessageToParts makes, in order.
+// Parts used to be numbered by their position in this list, so it also maps those part IDs to the current ones.
+func (m *Message) PartIndexes() []int {
+	if m.ItemType == 6 || m.ItemType == 4 || m.BalloonBundleID != "" {
+		return []int{0}
+	}
+	partIndexes := []int{}
+	for componentIndex, combinedComponent := range m.CombinedComponents {
+		if m.componentHasPart(componentIndex, combinedComponent) {
+			partIndexes = append(partIndexes, componentIndex)
+		}
 	}
+	if len(partIndexes) == 0 {
+		return []int{0}
+	}
+	return partIndexes
+}
 
-	return parts, nil
+// componentHasPart returns whether ConvertMessageToParts makes a part of the component.
+func (m *Message) componentHasPart(componentIndex int, combinedComponent CombinedComponent) bool {
+	hasEditedPart := componentIndex < len(m.EditedMessageParts) &&
+		m.EditedMessageParts[componentIndex].Status != EditedMessageStatusOriginal
+	switch combinedComponent.(type) {
+	case CombinedComponentAttachment:
+		return true
+	case CombinedComponentText:
+		return len(m.AttributedBodyText) > 0 && (!m.IsPartEdited(componentIndex) || hasEditedPart)
+	case CombinedComponentRetraction:
+		return hasEditedPart
+	default:
+		return false
+	}
 }
 
 func (m *Message) ConvertAppMessageToMessagePart() *bridgev2.ConvertedMessagePart {
@@ -396,7 +517,7 @@ func (m *Message) ConvertMessageText() *bridgev2.ConvertedMessagePart {
 	return part
 }
 
-func (m *Message) ConvertEditedMessagePart(componentIndex int) *bridgev2.ConvertedMessagePart {
+func (m *Message) ConvertEditedMessagePart(componentIndex int, resolveMention MentionResolver) *bridgev2.ConvertedMessagePart {
 	editedMessageParts := m.EditedMessageParts
 
 	if componentIndex >= len(editedMessageParts) {
@@ -416,58 +537,150 @@ func (m *Message) ConvertEditedMessagePart(componentIndex int) *bridgev2.Convert
 			break
 		}
 		finalEdit := editedMessagePart.EditHistory[len(editedMessagePart.EditHistory)-1]
-		if finalEdit.Text == nil {
-			convertedMessagePart.Content = &event.MessageEventContent{
-				MsgType: event.MsgNotice,
-				Body:    "Message edited but final edit contained no text",
-			}
-			break
-		}
-		text := *finalEdit.Text
+		convertedMessagePart.Content = convertEditedEvent(finalEdit, resolveMention)
 
-		convertedMessagePart.Content = &event.MessageEventContent{
-			MsgType: event.MsgText,
-			Body:    template.HTMLEscapeString(text),
+	case EditedMessageStatusUnsent:
+		convertedMessagePart.Content = m.ConvertUnsentNotice("this message part", resolveMention)
+	case EditedMessageStatusOriginal:
+		return nil
+	}
+	return convertedMessagePart
+}
+
+// ConvertUnsentNotice returns a notice saying who unsent what, and how long after sending it they did.
+func (m *Message) ConvertUnsentNotice(what string, resolveMention MentionResolver) *event.MessageEventContent {
+	who := "You"
+	if !m.IsFromMe {
+		who = m.Sender.LocalID
+	}
+	suffix := "."
+	unsentAt := m.RetractedAt
+	if unsentAt.IsZero() {
+		unsentAt = m.EditedAt
+	}
+	if !unsentAt.IsZero() {
+		if readableDateTimeDiff := dateTimeDiff(m.CreatedAt, unsentAt); readableDateTimeDiff != "" {
+			suffix = fmt.Sprintf(" %s after sending%s", readableDateTimeDiff, suffix)
+		}
+	}
+	content := &event.MessageEventContent{
+		MsgType: event.MsgNotice,
+		Body:    fmt.Sprintf("%s unsent %s%s", who, what, suffix),
+		// The sender is shown as a pill without notifying them
+		Mentions: &event.Mentions{},
+	}
+	if !m.IsFromMe && resolveMention != nil {
+		if senderMXID := resolveMention(m.Sender.LocalID); senderMXID != "" {
+			content.Format = event.FormatHTML
+			content.FormattedBody = fmt.Sprintf("%s unsent %s%s", GetMentionText(senderMXID, who), html.EscapeString(what), html.EscapeString(suffix))
 		}
+	}
+	return content
+}
 
-		finalEditCombinedComponents := ConvertArchivablesToCombinedComponents(finalEdit.Components, finalEdit.Text)
-		if len(finalEditCombinedComponents) > 0 {
-			lastFinalEditCombinedComponent := finalEditCombinedComponents[len(finalEditCombinedComponents)-1]
-			if combinedComponentText, ok := lastFinalEditCombinedComponent.(CombinedComponentText); ok {
-				if len(combinedComponentText.TextRangeEffects) > 0 {
-					convertedMessagePart.Content.Format = event.FormatHTML
-					convertedMessagePart.Content.FormattedBody = FormatTextRangeEffectsOnText(text, combinedComponentText.TextRangeEffects)
-					break
-				}
-			}
+// IsFullyUnsent returns whether the message was unsent as a whole, rather than only some of its parts.
+// Without message_summary_info there's no telling which parts were unsent, so all of them are.
+func (m *Message) IsFullyUnsent() bool {
+	if !m.IsRetracted {
+		return false
+	}
+	for _, editedMessagePart := range m.EditedMessageParts {
+		if editedMessagePart.Status != EditedMessageStatusUnsent {
+			return false
 		}
+	}
+	return true
+}
 
-	case EditedMessageStatusUnsent:
-		who := "You"
-		if !m.IsFromMe {
-			who = "Sender"
+// IsPartUnsent returns whether the part with the given index was unsent.
+func (m *Message) IsPartUnsent(partIndex int) bool {
+	return partIndex >= 0 && partIndex < len(m.EditedMessageParts) && m.EditedMessageParts[partIndex].Status == EditedMessageStatusUnsent
+}
+
+// convertEditedEvent returns the content of a part as it was after an edit.
+func convertEditedEvent(edit EditedEvent, resolveMention MentionResolver) *event.MessageEventContent {
+	if edit.Text == nil {
+		return &event.MessageEventContent{
+			MsgType: event.MsgNotice,
+			Body:    "Message edited but the edit contained no text",
 		}
-		suffix := "."
-		if !m.EditedAt.IsZero() {
-			if readableDateTimeDiff := dateTimeDiff(m.CreatedAt, m.EditedAt); readableDateTimeDiff != "" {
-				suffix = fmt.Sprintf(" %s after sending%s", readableDateTimeDiff, suffix)
+	}
+	text := *edit.Text
+	content := &event.MessageEventContent{
+		MsgType: event.MsgText,
+		Body:    text,
+	}
+
+	var editCombinedComponents []CombinedComponent
+	if edit.AttributedBody != nil {
+		editCombinedComponents = ConvertAttributedStringToCombinedComponents(edit.AttributedBody)
+	}
+	if len(editCombinedComponents) > 0 {
+		lastEditCombinedComponent := editCombinedComponents[len(editCombinedComponents)-1]
+		if combinedComponentText, ok := lastEditCombinedComponent.(CombinedComponentText); ok {
+			var textRangeEffects []TextRangeEffect
+			textRangeEffects, content.Mentions = resolveMentions(combinedComponentText.TextRangeEffects, resolveMention)
+			if body, formattedBody := RenderTextRangeEffects(text, textRangeEffects); formattedBody != "" {
+				content.Body = body
+				content.Format = event.FormatHTML
+				content.FormattedBody = formattedBody
 			}
 		}
-		convertedMessagePart.Content = &event.MessageEventContent{
-			MsgType: event.MsgNotice,
-			Body:    fmt.Sprintf("%s unsent this message part%s", who, suffix),
+	}
+	return content
+}
+
+// PartEdit is one entry of the edit history of a message part.
+type PartEdit struct {
+	PartIndex int
+	// Index into the part's EditHistory, which is never 0 as that's the original text
+	EditIndex int
+	Edit      EditedEvent
+}
+
+// GetPartEdits returns every edit of every part in the order they were made, leaving out the original texts.
+func (m *Message) GetPartEdits() []PartEdit {
+	partEdits := []PartEdit{}
+	for partIndex, editedMessagePart := range m.EditedMessageParts {
+		if editedMessagePart.Status != EditedMessageStatusEdited {
+			continue
 		}
-		if !m.IsFromMe {
-			username := "temp"
-			server := "temp"
-			name := "temp"
-			convertedMessagePart.Content.Format = event.FormatHTML
-			convertedMessagePart.Content.FormattedBody = fmt.Sprintf("%s unsent this message part%s", GetMentionText(username, server, name), suffix)
+		for editIndex := 1; editIndex < len(editedMessagePart.EditHistory); editIndex++ {
+			partEdits = append(partEdits, PartEdit{
+				PartIndex: partIndex,
+				EditIndex: editIndex,
+				Edit:      editedMessagePart.EditHistory[editIndex],
+			})
 		}
-	case EditedMessageStatusOriginal:
-		return nil
 	}
-	return convertedMessagePart
+	slices.SortStableFunc(partEdits, func(a, b PartEdit) int {
+		return cmp.Compare(a.Edit.Date, b.Edit.Date)
+	})
+	return partEdits
+}
+
+// ConvertPartEdit converts a part as it was after one of its edits.
+func (m *Message) ConvertPartEdit(partEdit PartEdit, resolveMention MentionResolver) *bridgev2.ConvertedMessagePart {
+	return &bridgev2.ConvertedMessagePart{
+		ID:      networkid.PartID(strconv.Itoa(partEdit.PartIndex)),
+		Type:    event.EventMessage,
+		Content: convertEditedEvent(partEdit.Edit, resolveMention),
+	}
+}
+
+// WithoutEdits returns a copy of the message with edited parts as they were originally sent,
+// so its edits can be bridged afterwards.
+func (m Message) WithoutEdits() Message {
+	editedMessageParts := make([]*EditedMessagePart, 0, len(m.EditedMessageParts))
+	for _, editedMessagePart := range m.EditedMessageParts {
+		original := *editedMessagePart
+		if original.Status == EditedMessageStatusEdited && len(original.EditHistory) > 1 {
+			original.EditHistory = original.EditHistory[:1]
+		}
+		editedMessageParts = append(editedMessageParts, &original)
+	}
+	m.EditedMessageParts = editedMessageParts
+	return m
 }
 
 func (a *Attachment) ConvertAttachmentToConvertedMessagePart(ctx context.Context, intent bridgev2.MatrixAPI, roomId id.RoomID, attachmentMeta *AttachmentMeta) *bridgev2.ConvertedMessagePart {
@@ -498,14 +711,17 @@ func (a *Attachment) ConvertAttachmentToConvertedMessagePart(ctx context.Context
 
 	switch {
 	case strings.HasPrefix(mimeType, "image"):
-		convertedMessagePart.Content.Info.Height = int(*attachmentMeta.Height)
-		convertedMessagePart.Content.Info.Width = int(*attachmentMeta.Width)
+		// Inline sizes are only archived for some images, so they're left out when unknown
+		if attachmentMeta != nil && attachmentMeta.Height != nil && attachmentMeta.Width != nil {
+			convertedMessagePart.Content.Info.Height = int(*attachmentMeta.Height)
+			convertedMessagePart.Content.Info.Width = int(*attachmentMeta.Width)
+		}
 		convertedMessagePart.Content.MsgType = event.MsgImage
 	case strings.HasPrefix(mimeType, "video"):
 		convertedMessagePart.Content.MsgType = event.MsgVideo
 	case strings.HasPrefix(mimeType, "audio"):
 		convertedMessagePart.Content.MsgType = event.MsgAudio
-		if len(*attachmentMeta.Transcription) != 0 {
+		if attachmentMeta != nil && attachmentMeta.Transcription != nil && len(*attachmentMeta.Transcription) != 0 {
 			convertedMessagePart.Content.Body += fmt.Sprintf(" | Transcript: %s", *attachmentMeta.Transcription)
 		}
 	default:
@@ -0,0 +1,187 @@
+package macos_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"testing"
+
+	"github.com/GroveJay/matrix-macOS-Messages-bridge/pkg/macos"
+	"github.com/GroveJay/matrix-macOS-Messages-bridge/pkg/macos/macostest"
+	"maunium.net/go/mautrix/event"
+)
+
+func TestConvertAttachmentToConvertedMessagePart(t *testing.T) {
+	height, width := 480.0, 640.0
+	transcription := "see you soon"
+	tests := []struct {
+		name       string
+		mimeType   string
+		meta       macos.AttachmentMeta
+		wantType   event.MessageType
+		wantBody   string
+		wantHeight int
+		wantWidth  int
+	}{
+		{"audio without transcription", "audio/x-caf", macos.AttachmentMeta{}, event.MsgAudio, "file", 0, 0},
+		{"audio with transcription", "audio/x-caf", macos.AttachmentMeta{Transcription: &transcription}, event.MsgAudio, "file | Transcript: see you soon", 0, 0},
+		{"image without size", "image/jpeg", macos.AttachmentMeta{}, event.MsgImage, "file", 0, 0},
+		{"image with only a height", "image/jpeg", macos.AttachmentMeta{Height: &height}, event.MsgImage, "file", 0, 0},
+		{"image with size", "image/jpeg", macos.AttachmentMeta{Height: &height, Width: &width}, event.MsgImage, "file", 480, 640},
+		{"other file", "application/pdf", macos.AttachmentMeta{}, event.MsgFile, "file", 0, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "file")
+			if err := os.WriteFile(path, []byte("content"), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			attachment := &macos.Attachment{PathOnDisk: path, MimeType: test.mimeType, FileName: "file"}
+			intent := macostest.NewFakeMatrixAPI("")
+			part := attachment.ConvertAttachmentToConvertedMessagePart(context.Background(), intent, "!room:example.com", &test.meta)
+			if part.Content.MsgType != test.wantType {
+				t.Errorf("msgtype = %q, want %q", part.Content.MsgType, test.wantType)
+			}
+			if part.Content.Body != test.wantBody {
+				t.Errorf("body = %q, want %q", part.Content.Body, test.wantBody)
+			}
+			if part.Content.Info.Height != test.wantHeight || part.Content.Info.Width != test.wantWidth {
+				t.Errorf("size = %dx%d, want %dx%d", part.Content.Info.Width, part.Content.Info.Height, test.wantWidth, test.wantHeight)
+			}
+			if uploads := intent.Uploads(); len(uploads) != 1 || string(uploads[0].Data) != "content" {
+				t.Errorf("uploads = %v, want the attachment's content once", uploads)
+			}
+			if uploads := intent.Uploads(); len(uploads) == 1 && (uploads[0].FileName != "file" || uploads[0].MimeType != test.mimeType) {
+				t.Errorf("uploaded %s (%s), want file (%s)", uploads[0].FileName, uploads[0].MimeType, test.mimeType)
+			}
+			if data, err := intent.DownloadMedia(context.Background(), part.Content.URL, nil); err != nil || string(data) != "content" {
+				t.Errorf("downloading %s = %q (%v), want the attachment's content", part.Content.URL, data, err)
+			}
+		})
+	}
+}
+
+func TestConvertMessageToPartsAttachments(t *testing.T) {
+	dir := t.TempDir()
+	message := &macos.Message{
+		CombinedComponents: []macos.CombinedComponent{
+			macos.CombinedComponentAttachment{},
+			macos.CombinedComponentAttachment{},
+			macos.CombinedComponentAttachment{},
+		},
+	}
+	for _, name := range []string{"first.pdf", "second.pdf"} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(name), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		message.Attachments = append(message.Attachments, &macos.Attachment{PathOnDisk: path, MimeType: "application/pdf", FileName: name})
+	}
+	intent := macostest.NewFakeMatrixAPI("")
+	parts, err := message.ConvertMessageToParts(context.Background(), intent, "!room:example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Each attachment component gets the next attachment, and there are only attachments for the first two
+	wantBodies := []string{"first.pdf", "second.pdf", "attachment does not exist"}
+	if len(parts) != len(wantBodies) {
+		t.Fatalf("got %d parts, want %d", len(parts), len(wantBodies))
+	}
+	for i, part := range parts {
+		if part.Content.Body != wantBodies[i] {
+			t.Errorf("part %d body = %q, want %q", i, part.Content.Body, wantBodies[i])
+		}
+	}
+	uploads := intent.Uploads()
+	if len(uploads) != 2 || string(uploads[0].Data) != "first.pdf" || string(uploads[1].Data) != "second.pdf" {
+		t.Errorf("uploads = %v, want each attachment once, in order", uploads)
+	}
+}
+
+func TestPartIndexes(t *testing.T) {
+	text := macos.CombinedComponentText{}
+	attachment := macos.CombinedComponentAttachment{}
+	retraction := macos.CombinedComponentRetraction{}
+	tests := []struct {
+		name    string
+		message macos.Message
+		want    []int
+	}{
+		{"plain text", macos.Message{AttributedBodyText: "hi", CombinedComponents: []macos.CombinedComponent{text}}, []int{0}},
+		{"no components", macos.Message{Text: "hi"}, []int{0}},
+		{"app message", macos.Message{BalloonBundleID: "com.apple.messages.URLBalloonProvider", CombinedComponents: []macos.CombinedComponent{text, text}}, []int{0}},
+		{"attachments and text", macos.Message{
+			AttributedBodyText: "￼￼hi",
+			CombinedComponents: []macos.CombinedComponent{attachment, attachment, text},
+		}, []int{0, 1, 2}},
+		{"unsent part", macos.Message{
+			AttributedBodyText: "￼hi",
+			CombinedComponents: []macos.CombinedComponent{retraction, attachment, text},
+			EditedMessageParts: []*macos.EditedMessagePart{
+				{Status: macos.EditedMessageStatusUnsent},
+				{Status: macos.EditedMessageStatusOriginal},
+				{Status: macos.EditedMessageStatusOriginal},
+			},
+		}, []int{0, 1, 2}},
+		{"retraction without a summary", macos.Message{
+			AttributedBodyText: "￼hi",
+			CombinedComponents: []macos.CombinedComponent{retraction, attachment, text},
+		}, []int{1, 2}},
+		{"original part", macos.Message{
+			AttributedBodyText: "hi",
+			CombinedComponents: []macos.CombinedComponent{retraction, retraction, text},
+			EditedMessageParts: []*macos.EditedMessagePart{
+				{Status: macos.EditedMessageStatusOriginal},
+				{Status: macos.EditedMessageStatusOriginal},
+				{Status: macos.EditedMessageStatusOriginal},
+			},
+		}, []int{2}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.message.PartIndexes(); !slices.Equal(got, test.want) {
+				t.Errorf("PartIndexes() = %v, want %v", got, test.want)
+			}
+			// The indexes are the IDs of the parts ConvertMessageToParts makes
+			parts, err := test.message.ConvertMessageToParts(context.Background(), macostest.NewFakeMatrixAPI(""), "!room:example.com", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var partIDs []int
+			for _, part := range parts {
+				partID, err := strconv.Atoi(string(part.ID))
+				if err != nil {
+					t.Fatal(err)
+				}
+				partIDs = append(partIDs, partID)
+			}
+			if !slices.Equal(partIDs, test.want) {
+				t.Errorf("part IDs = %v, want %v", partIDs, test.want)
+			}
+		})
+	}
+}
+
+func TestMessageCursorAfter(t *testing.T) {
+	cursor := macos.MessageCursor{Date: 100, RowID: 5}
+	tests := []struct {
+		name  string
+		other macos.MessageCursor
+		want  bool
+	}{
+		{"later date", macos.MessageCursor{Date: 101, RowID: 1}, true},
+		{"same date, later row", macos.MessageCursor{Date: 100, RowID: 6}, true},
+		{"same position", cursor, false},
+		{"same date, earlier row", macos.MessageCursor{Date: 100, RowID: 4}, false},
+		{"earlier date", macos.MessageCursor{Date: 99, RowID: 9}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.other.After(cursor); got != test.want {
+				t.Errorf("%v.After(%v) = %v, want %v", test.other, cursor, got, test.want)
+			}
+		})
+	}
+}
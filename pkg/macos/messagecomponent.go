@@ -1,7 +1,5 @@
 package macos
 
-import "fmt"
-
 type ComponentTypeKey string
 
 const (
@@ -19,6 +17,7 @@ const (
 	TextItalicAttributeName        ComponentTypeKey = "__kIMTextItalicAttributeName"
 	TextStrikethroughAttributeName ComponentTypeKey = "__kIMTextStrikethroughAttributeName"
 	TextEffectAttributeName        ComponentTypeKey = "__kIMTextEffectAttributeName"
+	MessagePartAttributeName       ComponentTypeKey = "__kIMMessagePartAttributeName"
 )
 
 type CombinedComponent any
@@ -33,209 +32,97 @@ type CombinedComponentText struct {
 
 type CombinedComponentRetraction struct{}
 
-type CombinedComponentResult interface{}
-
-type CombinedComponentResultNew struct {
-	CombinedComponent CombinedComponent
-}
-
-type CombinedComponentResultContinuation struct {
-	TextRangeEffect TextRangeEffect
-}
-
-func GetCombinedComponent(components []Archivable, text string, start int, end int, charIndexTable []int) CombinedComponentResult {
-	rangeStart := 0
-	rangeEnd := len(text)
-	if start > 0 && start < len(charIndexTable) {
-		rangeStart = charIndexTable[start]
-	}
-	if end > 0 && end < len(charIndexTable) {
-		rangeEnd = charIndexTable[end]
-	}
-	for index, component := range components {
-		keyName := component.as_nsstring()
-		if keyName != nil {
-			var keyNameAsInterface interface{} = *keyName
-			keyNameAsComponentTypeKey, ok := keyNameAsInterface.(ComponentTypeKey)
+// getTextEffects returns the effects of the attributes we know how to show, in the order the attributes were archived.
+func getTextEffects(attributes AttributeDictionary) []TextEffect {
+	textEffects := []TextEffect{}
+	hasStyles := false
+	for _, entry := range attributes {
+		switch ComponentTypeKey(entry.Key) {
+		case MentionConfirmedMention:
+			mention, _ := attributes.GetString(MentionConfirmedMention)
+			textEffects = append(textEffects, TextEffectMention{
+				Mention: mention,
+			})
+		case LinkAttributeName:
+			link, ok := attributes.GetString(LinkAttributeName)
 			if !ok {
-				continue
+				link = "#"
 			}
-			switch keyNameAsComponentTypeKey {
-			case FileTransferGUIDAttributeName:
-				attachmentMeta := GetAttachmentMetaFromComponents(components)
-				if attachmentMeta == nil {
-					return nil
-				}
-				return CombinedComponentResultNew{
-					CombinedComponent: CombinedComponentAttachment{
-						AttachmentMeta: *attachmentMeta,
-					},
-				}
-			case MentionConfirmedMention:
-				mentionIndex := index + 1
-				if mentionIndex >= len(components) {
-					return nil
-				}
-				mentionString := ""
-				if mentionStringRef := components[mentionIndex].as_nsstring(); mentionStringRef != nil {
-					mentionString = *mentionStringRef
-				}
-
-				return CombinedComponentResultContinuation{
-					TextRangeEffect: TextRangeEffect{
-						Start: rangeStart,
-						End:   rangeEnd,
-						TextEffect: TextEffectMention{
-							Mention: mentionString,
-						},
-					},
-				}
-			case LinkAttributeName:
-				linkIndex := index + 2
-				if linkIndex >= len(components) {
-					return nil
-				}
-				linkString := "#"
-				if linkStringRef := components[linkIndex].as_nsstring(); linkStringRef != nil {
-					linkString = *linkStringRef
-				}
-
-				return CombinedComponentResultContinuation{
-					TextRangeEffect: TextRangeEffect{
-						Start: rangeStart,
-						End:   rangeEnd,
-						TextEffect: TextEffectLink{
-							Link: linkString,
-						},
-					},
-				}
-			case OneTimeCodeAttributeName:
-				return CombinedComponentResultContinuation{
-					TextRangeEffect: TextRangeEffect{
-						Start:      rangeStart,
-						End:        rangeEnd,
-						TextEffect: TextEffectOTP{},
-					},
-				}
-			case CalendarEventAttributeName:
-				return CombinedComponentResultContinuation{
-					TextRangeEffect: TextRangeEffect{
-						Start: rangeStart,
-						End:   rangeEnd,
-						TextEffect: TextEffectConversion{
-							Conversion: ConversionTypeTimezone,
-						},
-					},
-				}
-			case TextBoldAttributeName, TextUnderlineAttributeName, TextItalicAttributeName, TextStrikethroughAttributeName:
-				resolvedStyles := ResolveStyles(components)
-				return CombinedComponentResultContinuation{
-					TextRangeEffect: TextRangeEffect{
-						Start: rangeStart,
-						End:   rangeEnd,
-						TextEffect: TextEffectStyles{
-							Styles: resolvedStyles,
-						},
-					},
-				}
-			case TextEffectAttributeName:
-				effectIndex := index + 1
-				if effectIndex >= len(components) {
-					return nil
-				}
-				var animation AnimationType = 0
-				if animationRef := components[effectIndex].as_nsnumber_int(); animationRef != nil {
-					animation = AnimationType(*animationRef)
-				}
-
-				return CombinedComponentResultContinuation{
-					TextRangeEffect: TextRangeEffect{
-						Start: rangeStart,
-						End:   rangeEnd,
-						TextEffect: TextEffectAnimation{
-							Animation: animation,
-						},
-					},
-				}
+			textEffects = append(textEffects, TextEffectLink{
+				Link: link,
+			})
+		case OneTimeCodeAttributeName:
+			textEffects = append(textEffects, TextEffectOTP{})
+		case CalendarEventAttributeName:
+			textEffects = append(textEffects, TextEffectConversion{
+				Conversion: ConversionTypeTimezone,
+			})
+		case TextBoldAttributeName, TextUnderlineAttributeName, TextItalicAttributeName, TextStrikethroughAttributeName:
+			if !hasStyles {
+				hasStyles = true
+				textEffects = append(textEffects, TextEffectStyles{
+					Styles: ResolveStyles(attributes),
+				})
 			}
+		case TextEffectAttributeName:
+			animation, _ := attributes.GetInt(TextEffectAttributeName)
+			textEffects = append(textEffects, TextEffectAnimation{
+				Animation: AnimationType(animation),
+			})
 		}
 	}
-	return CombinedComponentResultContinuation{
-		TextRangeEffect: TextRangeEffect{
-			Start:      rangeStart,
-			End:        rangeEnd,
-			TextEffect: TextEffectDefault{},
-		},
+	if len(textEffects) == 0 {
+		textEffects = append(textEffects, TextEffectDefault{})
 	}
+	return textEffects
 }
 
-func ConvertArchivablesToCombinedComponents(components []Archivable, componentString *string) []CombinedComponent {
-	// Skip first index as it was used to get the raw string
-	componentIndex := 1
+// ConvertAttributedStringToCombinedComponents groups the runs of an attributed body into attachments and
+// text, starting a new text component after an attachment or wherever the message part changes.
+// A run with several attributes becomes one text range effect for each of them.
+func ConvertAttributedStringToCombinedComponents(attributedString *AttributedString) []CombinedComponent {
 	combinedComponents := []CombinedComponent{}
-	// We want to index into the message text, so we need a table to align
-	// Apple's indexes with the actual chars, not the bytes
-	charIndexTable := []int{}
-	for index := range *componentString {
-		charIndexTable = append(charIndexTable, index)
-	}
-	currentEnd := 0
-	var currentStart int
-	// println(fmt.Sprintf("Going through %d components starting from %d, string: %s with length %d", len(components), componentIndex, *componentString, len(*componentString)))
-	// println(fmt.Sprintf("charIndexTable of length %d", len(charIndexTable)))
-
-	for componentIndex < len(components) {
-		currentComponent := components[componentIndex]
-		start, end := currentComponent.getRange()
-		// println(fmt.Sprintf("Component %d", componentIndex))
-		if start != nil && end != nil {
-			currentStart = currentEnd
-			currentEnd += int(*end)
-			// println(fmt.Sprintf("Setting current start and end to %d, %d", currentStart, currentEnd))
-		} else {
-			// println("Skipping component")
-			componentIndex += 1
+	// Apple's ranges count UTF-16 code units, so they're converted to byte offsets into the message text
+	byteOffsets := utf16ByteOffsets(attributedString.String)
+	lastMessagePart := int64(-1)
+	for _, run := range attributedString.Runs {
+		if run.Attributes.Has(FileTransferGUIDAttributeName) {
+			combinedComponents = append(combinedComponents, CombinedComponentAttachment{
+				AttachmentMeta: *GetAttachmentMetaFromAttributes(run.Attributes),
+			})
+			lastMessagePart = -1
 			continue
 		}
 
-		componentIndex += 1
-		numberAttributes := 0
-		if componentIndex < len(components) {
-			numberAttributes = components[componentIndex].getDictionaryLength()
+		rangeStart, rangeEnd := byteRange(byteOffsets, run.Location, run.Length)
+		textRangeEffects := []TextRangeEffect{}
+		for _, textEffect := range getTextEffects(run.Attributes) {
+			textRangeEffects = append(textRangeEffects, TextRangeEffect{
+				Start:      rangeStart,
+				End:        rangeEnd,
+				TextEffect: textEffect,
+			})
 		}
 
-		if numberAttributes > 0 {
-			componentIndex += 1
+		messagePart, hasMessagePart := run.Attributes.GetInt(MessagePartAttributeName)
+		if !hasMessagePart {
+			messagePart = lastMessagePart
 		}
-		// println(fmt.Sprintf("Getting %d dictionary objects from component %d", numberAttributes, componentIndex))
-		selectedComponents := getNDictionaryObjects(components, componentIndex, numberAttributes)
-		// println(fmt.Sprintf("Converting %d selectedComponents to combined component from componentIndex %d with %d attributes, currentStart %d, currentEnd %d", len(selectedComponents), componentIndex, numberAttributes, currentStart, currentEnd ))
-		if result := GetCombinedComponent(selectedComponents, *componentString, currentStart, currentEnd, charIndexTable); result != nil {
-			// println(fmt.Sprintf("combined component was not nil: %d", combinedComponentResult.Variant))
-			switch combinedComponentResult := result.(type) {
-			case CombinedComponentResultNew:
-				combinedComponents = append(combinedComponents, combinedComponentResult.CombinedComponent)
-			case CombinedComponentResultContinuation:
-				// println(fmt.Sprintf("Component type Continuation, current combined length: %d", len(combinedComponents)))
-				textRangeEffect := combinedComponentResult.TextRangeEffect
-				if len(combinedComponents) == 0 {
-					combinedComponents = append(combinedComponents, CombinedComponentText{
-						TextRangeEffects: []TextRangeEffect{textRangeEffect},
-					})
-				} else if combinedComponentText, ok := combinedComponents[len(combinedComponents)-1].(CombinedComponentText); ok {
-					// println(fmt.Sprintf("Adding attributes to last component at %d", len(combinedComponents)-1))
-					combinedComponentText.TextRangeEffects = append(combinedComponentText.TextRangeEffects, textRangeEffect)
-				} else {
-					combinedComponents = append(combinedComponents, CombinedComponentText{
-						TextRangeEffects: []TextRangeEffect{textRangeEffect},
-					})
-				}
-			default:
-				panic(fmt.Sprintf("invalid type: %T", combinedComponentResult))
-			}
+		lastIndex := len(combinedComponents) - 1
+		var lastText CombinedComponentText
+		isContinuation := false
+		if lastIndex >= 0 && messagePart == lastMessagePart {
+			lastText, isContinuation = combinedComponents[lastIndex].(CombinedComponentText)
+		}
+		if isContinuation {
+			lastText.TextRangeEffects = append(lastText.TextRangeEffects, textRangeEffects...)
+			combinedComponents[lastIndex] = lastText
+		} else {
+			combinedComponents = append(combinedComponents, CombinedComponentText{
+				TextRangeEffects: textRangeEffects,
+			})
 		}
-		// This feels like it has potential for an infinite loop if no components are returned?
-		componentIndex += len(selectedComponents)
+		lastMessagePart = messagePart
 	}
 	return combinedComponents
 }
@@ -6,7 +6,6 @@ import (
 	"database/sql"
 	"fmt"
 	"os"
-	"path/filepath"
 	"slices"
 	"strconv"
 	"strings"
@@ -23,26 +22,36 @@ import (
 
 type MacOSMessagesClient struct {
 	log                    *zerolog.Logger
+	scriptRunner           ScriptRunner
 	chatDB                 *sql.DB
 	chatDBPath             string
+	paths                  DataPaths
 	groupMemberQuery       *sql.Stmt
 	chatQuery              *sql.Stmt
+	recentChatsQuery       *sql.Stmt
 	groupActionQuery       *sql.Stmt
 	maxMessagesRowQuery    *sql.Stmt
 	maxMessagesTimeQuery   *sql.Stmt
 	newMessagesQuery       *sql.Stmt
 	messagesNewerThanQuery *sql.Stmt
+	messagesAfterQuery     *sql.Stmt
 	messagesBetweenQuery   *sql.Stmt
+	chatMessagesPageQuery  *sql.Stmt
+	chatTapbacksQuery      *sql.Stmt
+	messageCursorQuery     *sql.Stmt
+	outgoingStatusQuery    *sql.Stmt
 	newReceiptsQuery       *sql.Stmt
 	attachmentsQuery       *sql.Stmt
 }
 
-func GetMessagesClient(userName string, logger *zerolog.Logger) (*MacOSMessagesClient, error) {
+func GetMessagesClient(userName string, logger *zerolog.Logger, paths DataPaths, scriptRunner ScriptRunner) (*MacOSMessagesClient, error) {
 	client := &MacOSMessagesClient{
-		log: logger,
+		log:          logger,
+		scriptRunner: scriptRunner,
+		paths:        paths,
 	}
 	var err error
-	if client.chatDB, client.chatDBPath, err = openChatDB(); err != nil {
+	if client.chatDB, client.chatDBPath, err = openChatDB(paths); err != nil {
 		return nil, fmt.Errorf("failed to open chat db: %w", err)
 	}
 
@@ -52,6 +61,9 @@ func GetMessagesClient(userName string, logger *zerolog.Logger) (*MacOSMessagesC
 	if client.chatQuery, err = client.chatDB.Prepare(ChatQuery); err != nil {
 		return nil, fmt.Errorf("failed to prepare chat query: %w", err)
 	}
+	if client.recentChatsQuery, err = client.chatDB.Prepare(RecentChatsQuery); err != nil {
+		return nil, fmt.Errorf("failed to prepare recent chats query: %w", err)
+	}
 	if client.groupActionQuery, err = client.chatDB.Prepare(GroupActionQuery); err != nil {
 		return nil, fmt.Errorf("failed to prepare group action query: %w", err)
 	}
@@ -67,9 +79,24 @@ func GetMessagesClient(userName string, logger *zerolog.Logger) (*MacOSMessagesC
 	if client.messagesNewerThanQuery, err = client.chatDB.Prepare(MessagesNewerThanQuery); err != nil {
 		return nil, fmt.Errorf("failed to prepare newer than messages query: %w", err)
 	}
+	if client.messagesAfterQuery, err = client.chatDB.Prepare(MessagesAfterIngestCursorQuery); err != nil {
+		return nil, fmt.Errorf("failed to prepare messages after cursor query: %w", err)
+	}
 	if client.messagesBetweenQuery, err = client.chatDB.Prepare(MessagesBetweenQuery); err != nil {
 		return nil, fmt.Errorf("failed to prepare messages between query: %w", err)
 	}
+	if client.chatMessagesPageQuery, err = client.chatDB.Prepare(ChatMessagesPageQuery); err != nil {
+		return nil, fmt.Errorf("failed to prepare chat messages page query: %w", err)
+	}
+	if client.chatTapbacksQuery, err = client.chatDB.Prepare(ChatTapbacksSinceQuery); err != nil {
+		return nil, fmt.Errorf("failed to prepare chat tapbacks query: %w", err)
+	}
+	if client.messageCursorQuery, err = client.chatDB.Prepare(MessageCursorQuery); err != nil {
+		return nil, fmt.Errorf("failed to prepare message cursor query: %w", err)
+	}
+	if client.outgoingStatusQuery, err = client.chatDB.Prepare(OutgoingStatusQuery); err != nil {
+		return nil, fmt.Errorf("failed to prepare outgoing status query: %w", err)
+	}
 	if client.newReceiptsQuery, err = client.chatDB.Prepare(NewRecieptsQuery); err != nil {
 		return nil, fmt.Errorf("failed to prepare new reciepts query: %w", err)
 	}
@@ -88,8 +115,8 @@ func (c MacOSMessagesClient) GetChatDBPath() string {
 	return c.chatDBPath
 }
 
-func (c MacOSMessagesClient) GetChatMemberMap(chatID networkid.PortalID, selfUserID networkid.UserID) (map[networkid.UserID]bridgev2.ChatMember, error) {
-	if members, err := c.getGroupMembers(string(chatID)); err != nil {
+func (c MacOSMessagesClient) GetChatMemberMap(chatGUID string, selfUserID networkid.UserID) (map[networkid.UserID]bridgev2.ChatMember, error) {
+	if members, err := c.getGroupMembers(chatGUID); err != nil {
 		return nil, err
 	} else {
 		membersMap := make(map[networkid.UserID]bridgev2.ChatMember)
@@ -114,32 +141,31 @@ func (c MacOSMessagesClient) GetChatMemberMap(chatID networkid.PortalID, selfUse
 	}
 }
 
-func (c *MacOSMessagesClient) GetChatDetails(chatID networkid.PortalID) (*string, *bridgev2.Avatar, error) {
-	stringChatID := string(chatID)
-	chatRow := c.chatQuery.QueryRow(stringChatID)
+func (c *MacOSMessagesClient) GetChatDetails(chatGUID string) (*string, *bridgev2.Avatar, error) {
+	chatRow := c.chatQuery.QueryRow(chatGUID)
 	var name string
 	if err := chatRow.Scan(&name); err != nil {
 		return nil, nil, err
 	} else if name == "" {
-		name = stringChatID
+		name = chatGUID
 	}
-	avatarRow := c.groupActionQuery.QueryRow(ItemTypeAvatar, GroupActionSetAvatar, stringChatID)
+	avatarRow := c.groupActionQuery.QueryRow(ItemTypeAvatar, GroupActionSetAvatar, chatGUID)
 	var fileName string
 	var mimeType string
 	var path string
 
-	if err := avatarRow.Scan(path, mimeType, fileName); err != nil {
+	if err := avatarRow.Scan(&path, &mimeType, &fileName); err != nil {
 		if err != sql.ErrNoRows {
 			return &name, nil, err
 		}
 		return &name, nil, nil
 	}
-	path, err := ReplaceHomeDirectory(path)
+	path, err := c.paths.ResolvePath(path)
 	if err != nil {
 		return &name, nil, err
 	}
 	avatar := &bridgev2.Avatar{
-		ID: networkid.AvatarID(fmt.Sprintf("%s-%s", stringChatID, fileName)),
+		ID: networkid.AvatarID(fmt.Sprintf("%s-%s", chatGUID, fileName)),
 		Get: func(ctx context.Context) ([]byte, error) {
 			return os.ReadFile(path)
 		},
@@ -147,8 +173,28 @@ func (c *MacOSMessagesClient) GetChatDetails(chatID networkid.PortalID) (*string
 	return &name, avatar, nil
 }
 
+// GetRecentChats lists up to limit chats, most recently active first.
+func (c *MacOSMessagesClient) GetRecentChats(limit int) ([]*ChatSummary, error) {
+	res, err := c.recentChatsQuery.Query(limit)
+	if err != nil {
+		return nil, fmt.Errorf("error querying recent chats: %w", err)
+	}
+	defer res.Close()
+	var chats []*ChatSummary
+	for res.Next() {
+		var chat ChatSummary
+		var lastMessageDate int64
+		if err := res.Scan(&chat.GUID, &lastMessageDate); err != nil {
+			return chats, fmt.Errorf("error scanning row: %w", err)
+		}
+		chat.LastMessageAt = time.Unix(AppleEpochUnix, lastMessageDate)
+		chats = append(chats, &chat)
+	}
+	return chats, res.Err()
+}
+
 func (c *MacOSMessagesClient) GetAllChatIDsNames() (map[string]string, error) {
-	stdout, stderr, err := RunOsascript(GetChatIDsNames)
+	stdout, stderr, err := c.scriptRunner.RunScript(GetChatIDsNames)
 	if err != nil || len(stdout) == 0 || len(stderr) != 0 {
 		return nil, fmt.Errorf("%w:\nstdout:\n%s\nstderr:\n%s", err, stdout, stderr)
 	}
@@ -182,13 +228,9 @@ func (c *MacOSMessagesClient) GetMaxMessagesRow() (*int, error) {
 
 func (c *MacOSMessagesClient) GetMaxMessagesTime() (*int64, error) {
 	var maxMessagesTimeSQL sql.NullInt64
-	rows, err := c.maxMessagesTimeQuery.Query()
+	err := c.maxMessagesTimeQuery.QueryRow().Scan(&maxMessagesTimeSQL)
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch maximum message time: %w", err)
-	}
-	err = rows.Scan(&maxMessagesTimeSQL)
-	if err != nil {
-		return nil, fmt.Errorf("failed to scan maximum message time: %w", err)
 	} else if !maxMessagesTimeSQL.Valid {
 		return nil, fmt.Errorf("invalid maximum message time")
 	}
@@ -203,6 +245,15 @@ func (c *MacOSMessagesClient) GetMessagesAboveRowID(rowID int) ([]*Message, erro
 	return c.parseMessages(res)
 }
 
+// GetMessagesAfterIngestCursor returns messages created, edited or retracted after the cursor, in the order those changes happened.
+func (c *MacOSMessagesClient) GetMessagesAfterIngestCursor(cursor MessageCursor) ([]*Message, error) {
+	res, err := c.messagesAfterQuery.Query(cursor.Date, cursor.RowID)
+	if err != nil {
+		return nil, fmt.Errorf("error querying messages after %d/%d: %w", cursor.Date, cursor.RowID, err)
+	}
+	return c.parseMessages(res)
+}
+
 func (c *MacOSMessagesClient) GetMessagesNewerThan(t int64) ([]*Message, error) {
 	res, err := c.messagesNewerThanQuery.Query(t)
 	if err != nil {
@@ -219,6 +270,66 @@ func (c *MacOSMessagesClient) GetMessagesBetween(minRowID int, maxRowID int) ([]
 	return c.parseMessages(res)
 }
 
+func (c *MacOSMessagesClient) SendMessage(chatGUID string, text string) error {
+	stdout, stderr, err := c.scriptRunner.RunScript(SendMessage, chatGUID, text)
+	if err != nil || len(stderr) != 0 {
+		return fmt.Errorf("%w:\nstdout:\n%s\nstderr:\n%s", err, stdout, stderr)
+	}
+	return nil
+}
+
+func (c *MacOSMessagesClient) SendFile(chatGUID string, path string) error {
+	stdout, stderr, err := c.scriptRunner.RunScript(SendFile, chatGUID, path)
+	if err != nil || len(stderr) != 0 {
+		return fmt.Errorf("%w:\nstdout:\n%s\nstderr:\n%s", err, stdout, stderr)
+	}
+	return nil
+}
+
+// GetChatMessagesPage returns up to limit of the newest messages in the chat between the two cursors (exclusive),
+// in chronological order. Tapbacks are not included, see GetChatTapbacksSince.
+func (c *MacOSMessagesClient) GetChatMessagesPage(chatGUID string, after MessageCursor, before MessageCursor, limit int) ([]*Message, error) {
+	res, err := c.chatMessagesPageQuery.Query(chatGUID, after.Date, after.RowID, before.Date, before.RowID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("error querying messages in %s: %w", chatGUID, err)
+	}
+	messages, err := c.parseMessages(res)
+	if err != nil {
+		return nil, err
+	}
+	slices.Reverse(messages)
+	return messages, nil
+}
+
+func (c *MacOSMessagesClient) GetChatTapbacksSince(chatGUID string, minDate int64) ([]*Message, error) {
+	res, err := c.chatTapbacksQuery.Query(chatGUID, minDate)
+	if err != nil {
+		return nil, fmt.Errorf("error querying tapbacks in %s: %w", chatGUID, err)
+	}
+	return c.parseMessages(res)
+}
+
+func (c *MacOSMessagesClient) GetMessageCursor(messageGUID string) (*MessageCursor, error) {
+	var cursor MessageCursor
+	if err := c.messageCursorQuery.QueryRow(messageGUID).Scan(&cursor.Date, &cursor.RowID); err != nil {
+		return nil, fmt.Errorf("error querying cursor of %s: %w", messageGUID, err)
+	}
+	return &cursor, nil
+}
+
+func (c *MacOSMessagesClient) GetOutgoingStatus(messageGUID string) (*OutgoingStatus, error) {
+	status := &OutgoingStatus{GUID: messageGUID}
+	var dateDelivered int64
+	err := c.outgoingStatusQuery.QueryRow(messageGUID).Scan(&status.IsSent, &status.IsDelivered, &dateDelivered, &status.Error)
+	if err != nil {
+		return nil, fmt.Errorf("error querying outgoing status of %s: %w", messageGUID, err)
+	}
+	if dateDelivered != 0 {
+		status.DeliveredAt = time.Unix(AppleEpochUnix, dateDelivered)
+	}
+	return status, nil
+}
+
 func (c *MacOSMessagesClient) GetReadReceiptsSince(minDate time.Time) ([]*ReadReceipt, time.Time, error) {
 	origMinDate := minDate.UnixNano() - AppleEpochUnixNano
 	res, err := c.newReceiptsQuery.Query(origMinDate)
@@ -264,12 +375,11 @@ func (c *MacOSMessagesClient) GetReadReceiptsSince(minDate time.Time) ([]*ReadRe
 	return receipts, minDate, nil
 }
 
-func openChatDB() (*sql.DB, string, error) {
-	path, err := os.UserHomeDir()
+func openChatDB(paths DataPaths) (*sql.DB, string, error) {
+	path, err := paths.GetChatDBPath()
 	if err != nil {
-		return nil, "", fmt.Errorf("failed to get home directory: %w", err)
+		return nil, "", err
 	}
-	path = filepath.Join(path, "Library", "Messages", "chat.db")
 	db, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?mode=ro", path))
 	return db, path, err
 }
@@ -308,6 +418,7 @@ func (c *MacOSMessagesClient) parseMessages(res *sql.Rows) (messages []*Message,
 		err = res.Scan(&message.RowID, &message.GUID, &message.Date, &message.Subject, &message.Text, &attributedBody, &messageSummaryInfo,
 			&message.ChatGUID, &message.Sender.LocalID, &message.Sender.Service, &message.Target.LocalID, &message.Target.Service,
 			&message.IsFromMe, &message.DateRead, &message.IsDelivered, &message.IsSent, &message.IsEmote, &message.IsAudioMessage, &message.DateEdited, &message.DateRetracted,
+			&message.Error, &message.DateDelivered,
 			&message.ReplyToGUID, &threadOriginatorPart, &tapback.TargetGUID, &tapback.Type, &tapback.Emoji,
 			&newGroupTitle, &message.ItemType, &message.GroupActionType, &message.ThreadID, &message.BalloonBundleID)
 		if err != nil {
@@ -327,6 +438,9 @@ func (c *MacOSMessagesClient) parseMessages(res *sql.Rows) (messages []*Message,
 			message.RetractedAt = time.Unix(AppleEpochUnix, message.DateRetracted)
 			message.IsRetracted = true
 		}
+		if message.DateDelivered != 0 {
+			message.DeliveredAt = time.Unix(AppleEpochUnix, message.DateDelivered)
+		}
 		message.Attachments = make([]*Attachment, 0)
 		var ares *sql.Rows
 		ares, err = c.attachmentsQuery.Query(message.RowID)
@@ -342,6 +456,10 @@ func (c *MacOSMessagesClient) parseMessages(res *sql.Rows) (messages []*Message,
 				err = fmt.Errorf("error scanning attachment row for %d: %w", message.RowID, err)
 				return
 			}
+			if attachment.PathOnDisk, err = c.paths.ResolvePath(attachment.PathOnDisk); err != nil {
+				err = fmt.Errorf("error resolving attachment path for %d: %w", message.RowID, err)
+				return
+			}
 			if len(stickerUserInfo) > 0 {
 				plistDictionary := make(map[string]any, 0)
 				if err := plist.NewDecoder(bytes.NewReader(stickerUserInfo)).Decode(plistDictionary); err != nil {
@@ -360,16 +478,13 @@ func (c *MacOSMessagesClient) parseMessages(res *sql.Rows) (messages []*Message,
 			message.Attachments = append(message.Attachments, &attachment)
 		}
 		if len(attributedBody) > 0 {
-			if components, err := DecodeTypedStreamComponents(attributedBody); err != nil {
+			if attributedString, err := DecodeAttributedString(attributedBody); err != nil {
 				c.log.Warn().Msgf("[%d] failed to decode attributedBody of %s: %v", message.RowID, message.GUID, err)
 			} else {
-				message.Components = components
-				attributedBodyText := GetTextFromComponents(components)
-				if attributedBodyText != nil {
-					message.AttributedBodyText = *attributedBodyText
-					if message.BalloonBundleID == "" {
-						message.CombinedComponents = ConvertArchivablesToCombinedComponents(components, attributedBodyText)
-					}
+				message.AttributedBody = attributedString
+				message.AttributedBodyText = attributedString.String
+				if message.BalloonBundleID == "" {
+					message.CombinedComponents = ConvertAttributedStringToCombinedComponents(attributedString)
 				}
 			}
 		}
@@ -0,0 +1,176 @@
+package macos_test
+
+import (
+	"testing"
+	"time"
+	"unicode/utf16"
+
+	"github.com/GroveJay/matrix-macOS-Messages-bridge/pkg/macos"
+	"github.com/GroveJay/matrix-macOS-Messages-bridge/pkg/macos/macostest"
+	"github.com/rs/zerolog"
+)
+
+// textAttributedBody encodes an attributed body with each text as its own message part.
+func textAttributedBody(t *testing.T, texts ...string) []byte {
+	t.Helper()
+	attributedString := &macos.AttributedString{}
+	for part, text := range texts {
+		attributedString.Runs = append(attributedString.Runs, macos.AttributeRun{
+			Location:   len(utf16.Encode([]rune(attributedString.String))),
+			Length:     len(utf16.Encode([]rune(text))),
+			Attributes: macos.AttributeDictionary{{Key: string(macos.MessagePartAttributeName), Value: part}},
+		})
+		attributedString.String += text
+	}
+	attributedBody, err := macos.EncodeAttributedString(attributedString)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return attributedBody
+}
+
+func TestParseMessages(t *testing.T) {
+	chatDB := macostest.NewChatDB(t)
+	alice := chatDB.Handle("+15555550123", "iMessage")
+	bob := chatDB.Handle("bob@example.com", "iMessage")
+	chat := chatDB.DirectChat(alice)
+	group := chatDB.GroupChat("Friends", alice, bob)
+	changedAt := time.Date(2024, 1, 2, 12, 0, 0, 0, time.UTC)
+
+	text := chat.Text(alice, "hello")
+	fromMe := chat.Message().FromMe().Text("hi").Delivered(changedAt).Read(changedAt).Insert()
+	failed := chat.Message().FromMe().Text("lost").Failed(22).Insert()
+	attributed := chat.Message().From(alice).AttributedBody(textAttributedBody(t, "first", "second")).Insert()
+	attachment := chat.Message().From(alice).Attachment("~/Library/Messages/Attachments/ab/photo.jpg", "image/jpeg", []byte("jpeg")).Insert()
+	reply := chat.Message().From(alice).Text("reply").ReplyTo(attributed, 1).Insert()
+	tapback := chat.Message().FromMe().Tapback(attributed, 1, macos.TapbackLove).Insert()
+	removedTapback := chat.Message().FromMe().Tapback(attributed, 1, macos.TapbackLove+macos.TapbackRemoveOffset).Insert()
+	emojiTapback := chat.Message().From(alice).TapbackEmoji(text, 0, "🦊").Insert()
+	partUnsent := chat.Message().From(alice).
+		AttributedBody(textAttributedBody(t, "kept")).
+		Edited(changedAt, macostest.NewSummaryInfo(2).Unsend(1).Bytes()).
+		Insert()
+	retracted := chat.Text(alice, "gone")
+	chatDB.SetRetracted(retracted, changedAt)
+	rename := group.Message().From(bob).GroupAction(macos.ItemTypeName, 0, nil).GroupTitle("New name").Insert()
+
+	log := zerolog.Nop()
+	client, err := macos.GetMessagesClient("user", &log, chatDB.Paths(), macostest.NewFakeScriptRunner())
+	if err != nil {
+		t.Fatal(err)
+	}
+	messages, err := client.GetMessagesAboveRowID(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	byGUID := make(map[string]*macos.Message)
+	for _, message := range messages {
+		byGUID[message.GUID] = message
+	}
+
+	tests := []struct {
+		name    string
+		message *macostest.InsertedMessage
+		check   func(t *testing.T, message *macos.Message)
+	}{
+		{"text", text, func(t *testing.T, message *macos.Message) {
+			if message.Text != "hello" || message.ChatGUID != chat.GUID || message.IsFromMe {
+				t.Errorf("text = %q in %q from me %t, want %q in %q from them", message.Text, message.ChatGUID, message.IsFromMe, "hello", chat.GUID)
+			}
+			if message.Sender != (macos.Identifier{LocalID: alice.ID, Service: "iMessage"}) {
+				t.Errorf("sender = %+v, want %s", message.Sender, alice.ID)
+			}
+			if !message.CreatedAt.Equal(text.Date) {
+				t.Errorf("created at %s, want %s", message.CreatedAt, text.Date)
+			}
+		}},
+		{"from me", fromMe, func(t *testing.T, message *macos.Message) {
+			if !message.IsFromMe || message.Sender.LocalID != "" {
+				t.Errorf("from me %t with sender %q, want from me without a sender", message.IsFromMe, message.Sender.LocalID)
+			}
+			if !message.IsDelivered || !message.DeliveredAt.Equal(changedAt) || !message.IsRead || !message.ReadAt.Equal(changedAt) {
+				t.Errorf("delivered %t at %s, read %t at %s, want both at %s", message.IsDelivered, message.DeliveredAt, message.IsRead, message.ReadAt, changedAt)
+			}
+		}},
+		{"failed", failed, func(t *testing.T, message *macos.Message) {
+			if message.Error != 22 {
+				t.Errorf("error = %d, want 22", message.Error)
+			}
+		}},
+		{"attributed body", attributed, func(t *testing.T, message *macos.Message) {
+			if message.AttributedBodyText != "firstsecond" || len(message.CombinedComponents) != 2 {
+				t.Errorf("attributed body %q with %d components, want %q with 2", message.AttributedBodyText, len(message.CombinedComponents), "firstsecond")
+			}
+		}},
+		{"attachment", attachment, func(t *testing.T, message *macos.Message) {
+			if len(message.Attachments) != 1 {
+				t.Fatalf("got %d attachments, want 1", len(message.Attachments))
+			}
+			got := message.Attachments[0]
+			wantPath, _ := chatDB.Paths().ResolvePath("~/Library/Messages/Attachments/ab/photo.jpg")
+			if got.PathOnDisk != wantPath || got.MimeType != "image/jpeg" || got.FileName != "photo.jpg" {
+				t.Errorf("attachment = %s (%s) at %s, want photo.jpg (image/jpeg) at %s", got.FileName, got.MimeType, got.PathOnDisk, wantPath)
+			}
+		}},
+		{"reply", reply, func(t *testing.T, message *macos.Message) {
+			if message.ReplyToGUID != attributed.GUID || message.ReplyToPart != 1 {
+				t.Errorf("reply to %s part %d, want %s part 1", message.ReplyToGUID, message.ReplyToPart, attributed.GUID)
+			}
+		}},
+		{"tapback", tapback, func(t *testing.T, message *macos.Message) {
+			want := macos.Tapback{TargetGUID: attributed.GUID, Type: macos.TapbackLove, TargetPart: 1}
+			if message.Tapback == nil || *message.Tapback != want {
+				t.Errorf("tapback = %+v, want %+v", message.Tapback, want)
+			}
+		}},
+		{"removed tapback", removedTapback, func(t *testing.T, message *macos.Message) {
+			want := macos.Tapback{TargetGUID: attributed.GUID, Type: macos.TapbackLove, Remove: true, TargetPart: 1}
+			if message.Tapback == nil || *message.Tapback != want {
+				t.Errorf("tapback = %+v, want %+v", message.Tapback, want)
+			}
+		}},
+		{"emoji tapback", emojiTapback, func(t *testing.T, message *macos.Message) {
+			want := macos.Tapback{TargetGUID: text.GUID, Type: macos.TapbackEmoji, Emoji: "🦊"}
+			if message.Tapback == nil || *message.Tapback != want {
+				t.Errorf("tapback = %+v, want %+v", message.Tapback, want)
+			}
+		}},
+		{"part unsent", partUnsent, func(t *testing.T, message *macos.Message) {
+			if !message.IsEdited || !message.EditedAt.Equal(changedAt) {
+				t.Errorf("edited %t at %s, want edited at %s", message.IsEdited, message.EditedAt, changedAt)
+			}
+			// The unsent part has no text left, so its retraction is put back in its place
+			if len(message.CombinedComponents) != 2 {
+				t.Fatalf("got %d components, want 2", len(message.CombinedComponents))
+			}
+			if _, ok := message.CombinedComponents[1].(macos.CombinedComponentRetraction); !ok {
+				t.Errorf("component 1 = %T, want a retraction", message.CombinedComponents[1])
+			}
+			if len(message.EditedMessageParts) != 2 || message.EditedMessageParts[1].Status != macos.EditedMessageStatusUnsent {
+				t.Errorf("edited parts = %+v, want part 1 unsent", message.EditedMessageParts)
+			}
+		}},
+		{"retracted", retracted, func(t *testing.T, message *macos.Message) {
+			if !message.IsRetracted || !message.RetractedAt.Equal(changedAt) || message.Text != "" {
+				t.Errorf("retracted %t at %s with text %q, want retracted at %s without text", message.IsRetracted, message.RetractedAt, message.Text, changedAt)
+			}
+		}},
+		{"group rename", rename, func(t *testing.T, message *macos.Message) {
+			if message.ChatGUID != group.GUID || message.ItemType != macos.ItemTypeName || message.NewGroupName != "New name" {
+				t.Errorf("item type %d renaming %q to %q, want %d renaming %q to %q", message.ItemType, message.ChatGUID, message.NewGroupName, macos.ItemTypeName, group.GUID, "New name")
+			}
+		}},
+	}
+	if len(messages) != len(tests) {
+		t.Errorf("got %d messages, want %d", len(messages), len(tests))
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			message := byGUID[test.message.GUID]
+			if message == nil {
+				t.Fatalf("message %s wasn't read", test.message.GUID)
+			}
+			test.check(t, message)
+		})
+	}
+}
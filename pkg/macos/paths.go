@@ -0,0 +1,63 @@
+package macos
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// DataPaths locates the data read by the clients, so they can run against a copy of a Mac's home directory
+// (a backup, a Time Machine snapshot or a test fixture) rather than the home directory of the current user.
+// Empty fields resolve to their usual location in the home directory.
+type DataPaths struct {
+	// The macOS home directory that chat.db, the AddressBook sources and attachment paths are resolved in
+	Home               string
+	ChatDB             string
+	AddressBookSources string
+}
+
+func (p DataPaths) GetHome() (string, error) {
+	if p.Home != "" {
+		return p.Home, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get home directory: %w", err)
+	}
+	return home, nil
+}
+
+func (p DataPaths) GetChatDBPath() (string, error) {
+	if p.ChatDB != "" {
+		return p.ChatDB, nil
+	}
+	home, err := p.GetHome()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, "Library", "Messages", "chat.db"), nil
+}
+
+func (p DataPaths) GetAddressBookSourcesPath() (string, error) {
+	if p.AddressBookSources != "" {
+		return p.AddressBookSources, nil
+	}
+	home, err := p.GetHome()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, "Library", "Application Support", "AddressBook", "Sources"), nil
+}
+
+// ResolvePath expands a path relative to the home directory, like the attachment paths stored in chat.db.
+func (p DataPaths) ResolvePath(path string) (string, error) {
+	if !strings.HasPrefix(path, "~/") {
+		return path, nil
+	}
+	home, err := p.GetHome()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, path[2:]), nil
+}
@@ -26,6 +26,7 @@ SELECT
   message.ROWID, message.guid, message.date, COALESCE(message.subject, ''), COALESCE(message.text, ''), message.attributedBody, message.message_summary_info,
   chat.guid, COALESCE(sender_handle.id, ''), COALESCE(sender_handle.service, ''), COALESCE(target_handle.id, ''), COALESCE(target_handle.service, ''),
   message.is_from_me, message.date_read, message.is_delivered, message.is_sent, message.is_emote, message.is_audio_message, message.date_edited, message.date_retracted,
+  message.error, message.date_delivered,
   COALESCE(message.thread_originator_guid, ''), COALESCE(message.thread_originator_part, ''), COALESCE(message.associated_message_guid, ''), message.associated_message_type, COALESCE(message.associated_message_emoji, ''),
   message.group_title, message.item_type, message.group_action_type, chat.group_id, COALESCE(message.balloon_bundle_id, '')
 FROM message
@@ -48,6 +49,16 @@ FROM chat
 WHERE guid=$1
 `
 
+const RecentChatsQuery = `
+SELECT chat.guid, MAX(message.date) AS last_message_date
+FROM chat
+JOIN chat_message_join ON chat_message_join.chat_id = chat.ROWID
+JOIN message           ON chat_message_join.message_id = message.ROWID
+GROUP BY chat.ROWID
+ORDER BY last_message_date DESC
+LIMIT $1
+`
+
 const GroupActionQuery = `
 SELECT COALESCE(attachment.filename, ''), COALESCE(attachment.mime_type, ''), attachment.transfer_name
 FROM message
@@ -77,11 +88,46 @@ WHERE message.date > $1 OR message.date_edited > $1 OR message.date_retracted >
 ORDER BY COALESCE(message.date_retracted, COALESCE(message.date_edited, message.date)) ASC
 `
 
+const MessagesAfterIngestCursorQuery = baseMessagesQuery + `
+WHERE MAX(message.date, message.date_edited, message.date_retracted) > $1
+   OR (MAX(message.date, message.date_edited, message.date_retracted) = $1 AND message.ROWID > $2)
+ORDER BY MAX(message.date, message.date_edited, message.date_retracted) ASC, message.ROWID ASC
+`
+
 const MessagesBetweenQuery = baseMessagesQuery + `
 WHERE message.ROWID > $1 AND message.ROWID < $2
 ORDER BY message.date ASC
 `
 
+// Tapbacks are left out of chat pages and fetched separately, so they can be attached to the messages they target
+const ChatMessagesPageQuery = baseMessagesQuery + `
+WHERE chat.guid=$1
+  AND message.associated_message_type NOT BETWEEN 2000 AND 3999
+  AND (message.date > $2 OR (message.date = $2 AND message.ROWID > $3))
+  AND (message.date < $4 OR (message.date = $4 AND message.ROWID < $5))
+ORDER BY message.date DESC, message.ROWID DESC
+LIMIT $6
+`
+
+const ChatTapbacksSinceQuery = baseMessagesQuery + `
+WHERE chat.guid=$1
+  AND message.associated_message_type BETWEEN 2000 AND 3999
+  AND message.date >= $2
+ORDER BY message.date ASC, message.ROWID ASC
+`
+
+const MessageCursorQuery = `
+SELECT message.date, message.ROWID
+FROM message
+WHERE message.guid=$1
+`
+
+const OutgoingStatusQuery = `
+SELECT message.is_sent, message.is_delivered, message.date_delivered, message.error
+FROM message
+WHERE message.guid=$1
+`
+
 const NewRecieptsQuery = `
 SELECT chat.guid, message.guid, message.is_from_me, message.date_read
 FROM message
@@ -140,6 +186,22 @@ tell application "Messages"
 end tell
 `
 
+const SendMessage = `
+on run {chatGUID, messageText}
+	tell application "Messages"
+		send messageText to chat id chatGUID
+	end tell
+end run
+`
+
+const SendFile = `
+on run {chatGUID, filePath}
+	tell application "Messages"
+		send (POSIX file filePath) to chat id chatGUID
+	end tell
+end run
+`
+
 const GetOwnContactIDs = `
 tell application "Contacts"
 	set o to {}
@@ -0,0 +1,113 @@
+package macos
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var ErrAttachmentTooLarge = errors.New("attachment is too large")
+
+// AttachmentSpool is a bridge-owned directory that outgoing attachments are staged in
+// until Messages has picked them up.
+type AttachmentSpool struct {
+	dir     string
+	maxSize int64
+	maxAge  time.Duration
+}
+
+func NewAttachmentSpool(dir string, maxSize int64, maxAge time.Duration) (*AttachmentSpool, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("creating attachment spool %s: %w", dir, err)
+	}
+	return &AttachmentSpool{
+		dir:     dir,
+		maxSize: maxSize,
+		maxAge:  maxAge,
+	}, nil
+}
+
+func (s *AttachmentSpool) GetMaxSize() int64 {
+	return s.maxSize
+}
+
+// Stage copies the source file into its own directory in the spool, keeping the file name so
+// it shows up as the transfer name of the attachment in chat.db.
+func (s *AttachmentSpool) Stage(source *os.File, fileName string) (string, error) {
+	info, err := source.Stat()
+	if err != nil {
+		return "", fmt.Errorf("getting size of %s: %w", source.Name(), err)
+	}
+	if s.maxSize > 0 && info.Size() > s.maxSize {
+		return "", fmt.Errorf("%w: %d bytes (limit %d)", ErrAttachmentTooLarge, info.Size(), s.maxSize)
+	}
+	stagingDir, err := os.MkdirTemp(s.dir, "send-*")
+	if err != nil {
+		return "", fmt.Errorf("creating staging directory: %w", err)
+	}
+	path := filepath.Join(stagingDir, sanitizeFileName(fileName))
+	if err := copyFile(source, path); err != nil {
+		_ = os.RemoveAll(stagingDir)
+		return "", err
+	}
+	return path, nil
+}
+
+// Remove deletes a staged file along with its staging directory.
+func (s *AttachmentSpool) Remove(path string) error {
+	stagingDir := filepath.Dir(path)
+	if filepath.Dir(stagingDir) != filepath.Clean(s.dir) {
+		return fmt.Errorf("%s is not in the attachment spool", path)
+	}
+	return os.RemoveAll(stagingDir)
+}
+
+// Cleanup removes anything staged longer ago than the maximum age, which covers sends that
+// never showed up in chat.db.
+func (s *AttachmentSpool) Cleanup() error {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return fmt.Errorf("reading attachment spool %s: %w", s.dir, err)
+	}
+	var errs []error
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		if time.Since(info.ModTime()) > s.maxAge {
+			if err := os.RemoveAll(filepath.Join(s.dir, entry.Name())); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func copyFile(source *os.File, path string) error {
+	if _, err := source.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("seeking %s: %w", source.Name(), err)
+	}
+	destination, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("creating %s: %w", path, err)
+	}
+	if _, err := io.Copy(destination, source); err != nil {
+		_ = destination.Close()
+		return fmt.Errorf("copying to %s: %w", path, err)
+	}
+	return destination.Close()
+}
+
+func sanitizeFileName(fileName string) string {
+	fileName = strings.ReplaceAll(fileName, string(filepath.Separator), "_")
+	fileName = strings.TrimLeft(fileName, ".")
+	if fileName == "" {
+		return "attachment"
+	}
+	return fileName
+}
@@ -1,8 +1,16 @@
 package macos
 
 import (
+	"cmp"
 	"fmt"
-	"html/template"
+	"html"
+	"net/url"
+	"reflect"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"maunium.net/go/mautrix/id"
 )
 
 type Style int
@@ -41,6 +49,8 @@ const (
 
 type TextEffectMention struct {
 	Mention string
+	// UserID is the Matrix user the mention is rendered as a pill for, if it's known
+	UserID id.UserID
 }
 
 type TextEffectLink struct {
@@ -75,48 +85,203 @@ type TextRangeEffect struct {
 	TextEffect TextEffect
 }
 
-func FormatTextRangeEffectsOnText(text string, textRangeEffects []TextRangeEffect) string {
-	formattedText := ""
+// textSpan is a part of the text that one formatting tag applies to, after styles are split up and adjacent
+// ranges with the same effect are joined.
+type textSpan struct {
+	start  int
+	end    int
+	effect TextEffect
+}
+
+type textTag struct {
+	openHTML     string
+	closeHTML    string
+	openBody     string
+	closeBody    string
+	isAnchor     bool
+	isFormatting bool
+}
+
+// RenderTextRangeEffects renders the text covered by the effects as a plain text body with markdown-style
+// formatting, and as Matrix HTML. Effects may overlap, and tags are closed and reopened where needed to keep
+// them nested. The formatted body is empty if none of the effects add any formatting.
+func RenderTextRangeEffects(text string, textRangeEffects []TextRangeEffect) (body string, formattedBody string) {
+	spans := splitTextSpans(text, textRangeEffects)
+	if len(spans) == 0 {
+		return "", ""
+	}
+	boundaries := []int{}
+	for _, span := range spans {
+		boundaries = append(boundaries, span.start, span.end)
+	}
+	slices.Sort(boundaries)
+	boundaries = slices.Compact(boundaries)
+
+	var bodyBuilder, htmlBuilder strings.Builder
+	hasFormatting := false
+	var open []int
+	var openTags []textTag
+	closeTags := func(count int) {
+		for len(open) > count {
+			tag := openTags[len(openTags)-1]
+			htmlBuilder.WriteString(tag.closeHTML)
+			bodyBuilder.WriteString(tag.closeBody)
+			open = open[:len(open)-1]
+			openTags = openTags[:len(openTags)-1]
+		}
+	}
+	for i := 0; i+1 < len(boundaries); i++ {
+		segmentStart, segmentEnd := boundaries[i], boundaries[i+1]
+		// Spans are sorted so that ones that start earlier and end later are opened first
+		var active []int
+		var activeTags []textTag
+		hasAnchor := false
+		for spanIndex, span := range spans {
+			if span.start > segmentStart || span.end < segmentEnd {
+				continue
+			}
+			tag, ok := tagForEffect(text[span.start:span.end], span.effect)
+			if !ok || (tag.isAnchor && hasAnchor) {
+				continue
+			}
+			hasAnchor = hasAnchor || tag.isAnchor
+			active = append(active, spanIndex)
+			activeTags = append(activeTags, tag)
+		}
+		common := 0
+		for common < len(open) && common < len(active) && open[common] == active[common] {
+			common++
+		}
+		closeTags(common)
+		for index := common; index < len(active); index++ {
+			htmlBuilder.WriteString(activeTags[index].openHTML)
+			bodyBuilder.WriteString(activeTags[index].openBody)
+			hasFormatting = hasFormatting || activeTags[index].isFormatting
+			open = append(open, active[index])
+			openTags = append(openTags, activeTags[index])
+		}
+		segment := text[segmentStart:segmentEnd]
+		htmlBuilder.WriteString(strings.ReplaceAll(html.EscapeString(segment), "\n", "<br>"))
+		bodyBuilder.WriteString(segment)
+	}
+	closeTags(0)
+
+	if !hasFormatting {
+		return bodyBuilder.String(), ""
+	}
+	return bodyBuilder.String(), htmlBuilder.String()
+}
+
+// splitTextSpans turns the effects into spans of a single tag each, skipping any that are out of range or split a character.
+func splitTextSpans(text string, textRangeEffects []TextRangeEffect) []textSpan {
+	spans := []textSpan{}
 	for _, textRangeEffect := range textRangeEffects {
-		textRange := text[textRangeEffect.Start:textRangeEffect.End]
-		if len(textRange) != 0 {
-			formattedText = formattedText + ApplyTextRangeEffectToText(textRange, textRangeEffect.TextEffect)
+		start, end := textRangeEffect.Start, textRangeEffect.End
+		if start < 0 || end > len(text) || start >= end || !isCharBoundary(text, start) || !isCharBoundary(text, end) {
+			continue
+		}
+		if styles, ok := textRangeEffect.TextEffect.(TextEffectStyles); ok {
+			for _, style := range styles.Styles {
+				spans = append(spans, textSpan{start, end, TextEffectStyles{Styles: []Style{style}}})
+			}
+			continue
+		}
+		spans = append(spans, textSpan{start, end, textRangeEffect.TextEffect})
+	}
+	slices.SortStableFunc(spans, compareTextSpans)
+
+	// Join spans of the same effect that follow each other, e.g. bold text where only part of it is a link
+	joined := make([]textSpan, 0, len(spans))
+	for _, span := range spans {
+		merged := false
+		for index := range joined {
+			if joined[index].end == span.start && reflect.DeepEqual(joined[index].effect, span.effect) {
+				joined[index].end = span.end
+				merged = true
+				break
+			}
+		}
+		if !merged {
+			joined = append(joined, span)
 		}
 	}
-	return formattedText
+	slices.SortStableFunc(joined, compareTextSpans)
+	return joined
+}
+
+// compareTextSpans orders spans that start earlier and end later first, and links and mentions before styles of the same range.
+func compareTextSpans(a, b textSpan) int {
+	return cmp.Or(cmp.Compare(a.start, b.start), cmp.Compare(b.end, a.end), cmp.Compare(textSpanOrder(a), textSpanOrder(b)))
+}
+
+func textSpanOrder(span textSpan) int {
+	switch span.effect.(type) {
+	case TextEffectLink, TextEffectMention:
+		return 0
+	}
+	return 1
 }
 
-func ApplyTextRangeEffectToText(text string, textEffect TextEffect) string {
-	output := template.HTMLEscapeString(text)
+// tagForEffect returns the tags to wrap text with for an effect, or false if it adds no tags.
+func tagForEffect(text string, textEffect TextEffect) (textTag, bool) {
 	switch t := textEffect.(type) {
-	case TextEffectDefault:
-	case TextEffectMention:
-		username := "temp"
-		server := "temp"
-		output = GetMentionText(username, server, output)
-	case TextEffectLink:
-		output = fmt.Sprintf("<a href=\"%s\">%s</a>", t.Link, output)
-	case TextEffectOTP:
 	case TextEffectStyles:
-		for _, style := range t.Styles {
-			tag := ""
-			switch style {
-			case StyleBold:
-				tag = "b"
-			case StyleItalic:
-				tag = "i"
-			case StyleStrikethrough:
-				tag = "s"
-			case StyleUnderline:
-				tag = "u"
-			}
-			output = fmt.Sprintf("<%s>%s<%s>", tag, output, tag)
+		if len(t.Styles) != 1 {
+			return textTag{}, false
+		}
+		switch t.Styles[0] {
+		case StyleBold:
+			return textTag{openHTML: "<strong>", closeHTML: "</strong>", openBody: "**", closeBody: "**", isFormatting: true}, true
+		case StyleItalic:
+			return textTag{openHTML: "<em>", closeHTML: "</em>", openBody: "_", closeBody: "_", isFormatting: true}, true
+		case StyleStrikethrough:
+			return textTag{openHTML: "<del>", closeHTML: "</del>", openBody: "~~", closeBody: "~~", isFormatting: true}, true
+		case StyleUnderline:
+			return textTag{openHTML: "<u>", closeHTML: "</u>", isFormatting: true}, true
 		}
-	case TextEffectAnimation:
-		// TODO: Be incredibly fancy and animate with css inline? lol idk
-	case TextEffectConversion:
-	default:
-		panic(fmt.Sprintf("invalid type: %T", t))
+	case TextEffectLink:
+		link, ok := sanitizeLink(t.Link)
+		if !ok {
+			return textTag{}, false
+		}
+		tag := textTag{
+			openHTML:     fmt.Sprintf("<a href=\"%s\">", html.EscapeString(link)),
+			closeHTML:    "</a>",
+			isAnchor:     true,
+			isFormatting: true,
+		}
+		// Links that were detected in the text already read fine as plain text
+		if text != t.Link && strings.TrimPrefix(t.Link, "mailto:") != text && strings.TrimPrefix(t.Link, "tel:") != text {
+			tag.openBody = "["
+			tag.closeBody = fmt.Sprintf("](%s)", link)
+		}
+		return tag, true
+	case TextEffectMention:
+		uri := t.UserID.URI()
+		if t.UserID == "" || uri == nil {
+			return textTag{}, false
+		}
+		return textTag{
+			openHTML:     fmt.Sprintf("<a href=\"%s\">", html.EscapeString(uri.MatrixToURL())),
+			closeHTML:    "</a>",
+			isAnchor:     true,
+			isFormatting: true,
+		}, true
 	}
-	return output
+	return textTag{}, false
+}
+
+var allowedLinkSchemes = []string{"http", "https", "ftp", "mailto", "tel", "sms", "magnet", "matrix"}
+
+// sanitizeLink only allows links with schemes that Matrix clients will open, so they can't run scripts.
+func sanitizeLink(link string) (string, bool) {
+	parsed, err := url.Parse(strings.TrimSpace(link))
+	if err != nil || !slices.Contains(allowedLinkSchemes, strings.ToLower(parsed.Scheme)) {
+		return "", false
+	}
+	return parsed.String(), true
+}
+
+func isCharBoundary(text string, index int) bool {
+	return index == len(text) || utf8.RuneStart(text[index])
 }
@@ -0,0 +1,17 @@
+package macos
+
+// ScriptRunner runs an AppleScript with the given arguments, returning its stdout and stderr.
+// Everything that talks to Messages or Contacts through AppleScript goes through a ScriptRunner
+// so those paths can be exercised without a Mac.
+type ScriptRunner interface {
+	RunScript(script string, args ...string) (stdout string, stderr string, err error)
+}
+
+// OsascriptRunner runs scripts with the osascript binary.
+type OsascriptRunner struct{}
+
+var _ ScriptRunner = (*OsascriptRunner)(nil)
+
+func (OsascriptRunner) RunScript(script string, args ...string) (string, string, error) {
+	return RunOsascript(script, args...)
+}
@@ -3,11 +3,21 @@ package macos
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"math"
 	"strings"
 )
 
+var (
+	ErrTypedStreamInvalidHeader = errors.New("typedstream header is invalid")
+	ErrTypedStreamTruncated     = errors.New("typedstream ended unexpectedly")
+	ErrTypedStreamBadReference  = errors.New("typedstream references an entry that doesn't exist")
+	ErrTypedStreamUnknownTag    = errors.New("typedstream has an unexpected tag")
+)
+
+// DecodeTypedStreamComponents decodes the objects in a typedstream, as found in attributedBody.
+// Malformed input returns an error wrapping one of the ErrTypedStream errors rather than panicking.
 func DecodeTypedStreamComponents(encoded []byte) ([]Archivable, error) {
 	typedStreamDecoder := NewTypedStreamDecoder(encoded, false)
 	if components, err := typedStreamDecoder.decodeComponents(); err != nil {
@@ -81,7 +91,9 @@ func (t *typedStreamDecoder) log(indent int, format string, args ...interface{})
 }
 
 func (t *typedStreamDecoder) decodeComponents() (components []Archivable, err error) {
-	t.validateHeader()
+	if err := t.validateHeader(); err != nil {
+		return nil, err
+	}
 	for t.index < t.length {
 		t.log(0, "starting decode loop at %x", t.index)
 		if currentByte, err := t.getCurrentByte(); err != nil {
@@ -126,7 +138,7 @@ func (t *typedStreamDecoder) validateHeader() (err error) {
 		return fmt.Errorf("reading header system version: %w", err)
 	}
 	if *version != 4 || *signature != "streamtyped" || *systemVersion != 1000 {
-		return fmt.Errorf("invalid header: [version: %d, signature: %s, systemVersion: %d]", *version, *signature, *systemVersion)
+		return fmt.Errorf("%w: [version: %d, signature: %s, systemVersion: %d]", ErrTypedStreamInvalidHeader, *version, *signature, *systemVersion)
 	}
 	return nil
 }
@@ -167,13 +179,14 @@ func (t *typedStreamDecoder) readTypes(foundTypes []Type) (Archivable, error) {
 					}
 					outV = append(outV, archivable.OutputData...)
 				case ArchivableClass:
-					outV = append(outV, archivable.Class)
+					// As a pointer, so the placeholder below is recognized as an object still waiting for its data
+					outV = append(outV, &archivable.Class)
 				case ArchivableData:
 					outV = append(outV, archivable.OutputData...)
 				case ArchivablePlaceholder, ArchivableTypes:
 					// These cases are used internally in the objects table but should not be present in any output
 				default:
-					panic(fmt.Sprintf("invalid type: %T", archivable))
+					return nil, fmt.Errorf("%w: object of invalid type %T", ErrTypedStreamBadReference, archivable)
 				}
 			}
 
@@ -215,6 +228,9 @@ func (t *typedStreamDecoder) readTypes(foundTypes []Type) (Archivable, error) {
 	}
 
 	if t.placeholder != nil {
+		if *t.placeholder >= len(t.objectTable) {
+			return nil, fmt.Errorf("%w: placeholder %d outside object table of length %d", ErrTypedStreamBadReference, *t.placeholder, len(t.objectTable))
+		}
 		if len(outV) != 0 {
 			spot := t.placeholder
 			last := outV[len(outV)-1]
@@ -253,6 +269,8 @@ func (t *typedStreamDecoder) readTypes(foundTypes []Type) (Archivable, error) {
 					// We got some data for a class that was already seen
 					if archivableObject, ok := seenObject.(ArchivableObject); ok {
 						archivableObject.OutputData = append(archivableObject.OutputData, outV...)
+						// archivableObject is a copy, so the table needs the appended data too
+						t.objectTable[*spot] = archivableObject
 						t.placeholder = nil
 						return t.objectTable[*spot], nil
 					}
@@ -287,7 +305,7 @@ func (t *typedStreamDecoder) readObject() (Archivable, error) {
 		}
 		switch class := readClass.(type) {
 		case ClassResultIndex:
-			return t.objectTable[class.Index], nil
+			return t.getObject(class.Index)
 		case ClassResultHierarchy:
 			t.objectTable = append(t.objectTable, class.ClassHierarchy...)
 		default:
@@ -304,9 +322,15 @@ func (t *typedStreamDecoder) readObject() (Archivable, error) {
 		if err != nil {
 			return nil, fmt.Errorf("error reading pointer for object: %w", err)
 		}
-		t.log(4, "Assuming pointer index %d is inside object table length %d", *index, len(t.objectTable))
-		return t.objectTable[*index], nil
+		return t.getObject(*index)
+	}
+}
+
+func (t *typedStreamDecoder) getObject(index int) (Archivable, error) {
+	if index < 0 || index >= len(t.objectTable) {
+		return nil, fmt.Errorf("%w: object %d outside object table of length %d", ErrTypedStreamBadReference, index, len(t.objectTable))
 	}
+	return t.objectTable[index], nil
 }
 
 func (t *typedStreamDecoder) readClass() (ClassResult, error) {
@@ -429,11 +453,17 @@ func (t *typedStreamDecoder) getTypes(embeded bool) ([]Type, error) {
 			t.objectTable = append(t.objectTable, ArchivableTypes{
 				Types: componentTypes,
 			})
-			seenEmbededTypeIndex := max(0, len(t.objectTable)-1)
-			t.seenEmbededTypes[seenEmbededTypeIndex] = struct{}{}
+			// Keyed by the types table index, which is what later references to the same type use
+			t.seenEmbededTypes[len(t.typesTable)] = struct{}{}
 		}
 		t.log(1, fmt.Sprintf("Adding %d types to typesTable", len(componentTypes)))
 		t.typesTable = append(t.typesTable, componentTypes)
+		// The embedded type is repeated as the type of the value that follows, which is a reference once it's in the table
+		if embeded {
+			if nextByte, err := t.getCurrentByte(); err == nil && int(*nextByte) == REFERENCE_TAG+len(t.typesTable)-1 {
+				t.index += 1
+			}
+		}
 		latestTypes := t.typesTable[len(t.typesTable)-1]
 		return latestTypes, nil
 	case END:
@@ -463,13 +493,10 @@ func (t *typedStreamDecoder) getTypes(embeded bool) ([]Type, error) {
 		if err != nil {
 			return nil, fmt.Errorf("reading pointer for type after first byte %x: %w", *firstByte, err)
 		}
-		var typesFromTable []Type
-		typesFromTable = nil
-		typesTableLength := len(t.typesTable)
-		t.log(1, "Getting types from table if ref %d is inside length %d", *refTag, typesTableLength)
-		if *refTag < typesTableLength {
-			typesFromTable = t.typesTable[*refTag]
+		if *refTag >= len(t.typesTable) {
+			return nil, fmt.Errorf("%w: types %d outside types table of length %d", ErrTypedStreamBadReference, *refTag, len(t.typesTable))
 		}
+		typesFromTable := t.typesTable[*refTag]
 
 		if embeded {
 			t.log(2, "Embedded call")
@@ -503,7 +530,7 @@ func (t *typedStreamDecoder) readPointer() (*int, error) {
 		return nil, fmt.Errorf("getting current byte for pointer: %w", err)
 	}
 	if *pointer < REFERENCE_TAG {
-		return nil, fmt.Errorf("pointer (%x) was less than reference tag (%x) at index %x", *pointer, REFERENCE_TAG, t.index)
+		return nil, fmt.Errorf("%w: pointer (%x) was less than reference tag (%x) at index %x", ErrTypedStreamUnknownTag, *pointer, REFERENCE_TAG, t.index)
 	}
 	offsetPointer := int(*pointer - REFERENCE_TAG)
 	t.index += 1
@@ -523,6 +550,9 @@ func (t *typedStreamDecoder) readType() ([]Type, error) {
 		return nil, fmt.Errorf("error reading %d bytes for type at %x: %w", *length, t.index, err)
 	}
 
+	if len(typesBytes) == 0 {
+		return nil, fmt.Errorf("%w: empty type at %x", ErrTypedStreamUnknownTag, t.index)
+	}
 	resultTypes := make([]Type, 0)
 	if typesBytes[0] == ARRAY {
 		t.log(2, "Type Bytes starts as array")
@@ -708,22 +738,21 @@ func (t *typedStreamDecoder) getNextByte() (*byte, error) {
 	if nextByteIndex < t.length {
 		return &(t.data[nextByteIndex]), nil
 	}
-	return nil, fmt.Errorf("next byte index %d out of range of encoded bytes (%d)", nextByteIndex, t.length)
+	return nil, fmt.Errorf("%w: next byte index %d out of range of encoded bytes (%d)", ErrTypedStreamTruncated, nextByteIndex, t.length)
 }
 
 func (t *typedStreamDecoder) readNBytes(n int) ([]byte, error) {
 	startIndex := t.index
-	endIndex := t.index + n
-	if endIndex < t.length {
-		t.index += n
-		return t.data[startIndex:endIndex], nil
+	if n < 0 || n > t.length-startIndex {
+		return nil, fmt.Errorf("%w: reading %d bytes at %d out of range of encoded bytes (%d)", ErrTypedStreamTruncated, n, startIndex, t.length)
 	}
-	return nil, fmt.Errorf("end index %d out of range of encoded bytes (%d)", endIndex, t.length)
+	t.index += n
+	return t.data[startIndex:t.index], nil
 }
 
 func (t *typedStreamDecoder) getCurrentByte() (*byte, error) {
 	if t.index < t.length {
 		return &(t.data[t.index]), nil
 	}
-	return nil, fmt.Errorf("index %d out of range of encoded bytes (%d)", t.index, t.length)
+	return nil, fmt.Errorf("%w: index %d out of range of encoded bytes (%d)", ErrTypedStreamTruncated, t.index, t.length)
 }
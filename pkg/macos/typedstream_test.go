@@ -0,0 +1,246 @@
+package macos
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// A plain "Hello" as Messages archives it in attributedBody.
+const helloAttributedBody = "040b73747265616d747970656481e803840140848484124e5341747472696275746564537472696e67008484084e534f626a656374008592848484084e53537472696e67019484012b0548656c6c6f86840269490105928484840c4e5344696374696f6e617279009484016901928496961d5f5f6b494d4d657373616765506172744174747269627574654e616d658692848484084e534e756d626572008484074e5356616c7565009484012a84999900868686"
+
+// A link, hand-assembled in the same layout: the URL is an NSURL attribute and the message part an NSNumber.
+const linkAttributedBody = "040b73747265616d747970656481e803840140848484124e5341747472696275746564537472696e67008484084e534f626a656374008592848484084e53537472696e67019484012b1368747470733a2f2f6578616d706c652e636f6d86840269490113928484840c4e5344696374696f6e61727900948401690292849696165f5f6b494d4c696e6b4174747269627574654e616d658692848484054e5355524c009484016300928496961368747470733a2f2f6578616d706c652e636f6d8686928496961d5f5f6b494d4d657373616765506172744174747269627574654e616d658692848484084e534e756d626572008484074e5356616c7565009484012a84999900868686"
+
+// An attachment with its inline size as NSNumber doubles followed by a link, and a second run with another link.
+// The first double adds "d" to the types table, the second refers back to it, and the second NSURL refers back to its class.
+const inlineMediaAttributedBody = "040b73747265616d747970656481e803840140848484124e5341747472696275746564537472696e67008484084e534f626a656374008592848484084e53537472696e67019484012b02616286840269490101928484840c4e5344696374696f6e61727900948401690492849696225f5f6b494d46696c655472616e73666572475549444174747269627574654e616d6586928496960961745f305f463041318692849696225f5f6b494d496e6c696e654d6564696157696474684174747269627574654e616d658692848484084e534e756d626572008484074e5356616c7565009484012a848401649d8300000000000084408692849696235f5f6b494d496e6c696e654d656469614865696768744174747269627574654e616d658692849d9c849d9d830000000000007e408692849696165f5f6b494d4c696e6b4174747269627574654e616d658692848484054e5355524c009484016300928496961168747470733a2f2f612e6578616d706c65868686970201928498990192849696165f5f6b494d4c696e6b4174747269627574654e616d65869284a49f00928496961168747470733a2f2f622e6578616d706c6586868686"
+
+func mustDecodeHex(t *testing.T, encoded string) []byte {
+	t.Helper()
+	data, err := hex.DecodeString(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// describeComponents renders decoded components as one line each, e.g. `NSString "Hello"` or `data 1 5`.
+func describeComponents(components []Archivable) []string {
+	lines := make([]string, 0, len(components))
+	for _, component := range components {
+		var name string
+		var outputData []any
+		switch typedComponent := component.(type) {
+		case ArchivableObject:
+			name, outputData = typedComponent.Class.name, typedComponent.OutputData
+		case *ArchivableData:
+			name, outputData = "data", typedComponent.OutputData
+		case ArchivableData:
+			name, outputData = "data", typedComponent.OutputData
+		default:
+			name = fmt.Sprintf("%T", component)
+		}
+		var line strings.Builder
+		line.WriteString(name)
+		for _, value := range outputData {
+			switch typedValue := value.(type) {
+			case *string:
+				fmt.Fprintf(&line, " %q", *typedValue)
+			case *int64:
+				fmt.Fprintf(&line, " %d", *typedValue)
+			case *uint64:
+				fmt.Fprintf(&line, " %d", *typedValue)
+			case *float64:
+				fmt.Fprintf(&line, " %g", *typedValue)
+			case *Class:
+				fmt.Fprintf(&line, " class %s", typedValue.name)
+			default:
+				fmt.Fprintf(&line, " %T", value)
+			}
+		}
+		lines = append(lines, line.String())
+	}
+	return lines
+}
+
+// Before objects of an already seen class were decoded as one object, each of them came out as a bare class
+// followed by its data, e.g. "data class NSString" and "data \"__kIMMessagePartAttributeName\"", so no attributes were found.
+// inlineMediaAttributedBody failed to decode at all, as the repeated reference to a new embedded type was read as the number.
+func TestDecodeTypedStreamComponentsFixtures(t *testing.T) {
+	tests := []struct {
+		name           string
+		encoded        string
+		wantComponents []string
+		wantString     *AttributedString
+	}{{
+		name:    "hello",
+		encoded: helloAttributedBody,
+		wantComponents: []string{
+			`NSString "Hello"`,
+			"data 1 5",
+			"NSDictionary 1",
+			`NSString "__kIMMessagePartAttributeName"`,
+			"NSNumber 0",
+		},
+		wantString: &AttributedString{
+			String: "Hello",
+			Runs: []AttributeRun{{
+				Length:     5,
+				Attributes: AttributeDictionary{{Key: string(MessagePartAttributeName), Value: int64(0)}},
+			}},
+		},
+	}, {
+		name:    "link",
+		encoded: linkAttributedBody,
+		wantComponents: []string{
+			`NSString "https://example.com"`,
+			"data 1 19",
+			"NSDictionary 2",
+			`NSString "__kIMLinkAttributeName"`,
+			"NSURL 0",
+			`NSString "https://example.com"`,
+			`NSString "__kIMMessagePartAttributeName"`,
+			"NSNumber 0",
+		},
+		wantString: &AttributedString{
+			String: "https://example.com",
+			Runs: []AttributeRun{{
+				Length: 19,
+				Attributes: AttributeDictionary{
+					{Key: string(LinkAttributeName), Value: NSURL("https://example.com")},
+					{Key: string(MessagePartAttributeName), Value: int64(0)},
+				},
+			}},
+		},
+	}, {
+		name:    "inline media",
+		encoded: inlineMediaAttributedBody,
+		wantComponents: []string{
+			`NSString "ab"`,
+			"data 1 1",
+			"NSDictionary 4",
+			`NSString "__kIMFileTransferGUIDAttributeName"`,
+			`NSString "at_0_F0A1"`,
+			`NSString "__kIMInlineMediaWidthAttributeName"`,
+			"NSNumber 640",
+			`NSString "__kIMInlineMediaHeightAttributeName"`,
+			"NSNumber 480",
+			`NSString "__kIMLinkAttributeName"`,
+			"NSURL 0",
+			`NSString "https://a.example"`,
+			"data 2 1",
+			"NSDictionary 1",
+			`NSString "__kIMLinkAttributeName"`,
+			"NSURL 0",
+			`NSString "https://b.example"`,
+		},
+		wantString: &AttributedString{
+			String: "ab",
+			Runs: []AttributeRun{{
+				Length: 1,
+				Attributes: AttributeDictionary{
+					{Key: string(FileTransferGUIDAttributeName), Value: "at_0_F0A1"},
+					{Key: string(InlineMediaWidthAttributeName), Value: 640.0},
+					{Key: string(InlineMediaHeightAttributeName), Value: 480.0},
+					{Key: string(LinkAttributeName), Value: NSURL("https://a.example")},
+				},
+			}, {
+				Location:   1,
+				Length:     1,
+				Attributes: AttributeDictionary{{Key: string(LinkAttributeName), Value: NSURL("https://b.example")}},
+			}},
+		},
+	}}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			encoded := mustDecodeHex(t, test.encoded)
+			components, err := DecodeTypedStreamComponents(encoded)
+			if err != nil {
+				t.Fatalf("DecodeTypedStreamComponents() error = %v", err)
+			}
+			if got := describeComponents(components); !reflect.DeepEqual(got, test.wantComponents) {
+				t.Errorf("DecodeTypedStreamComponents() =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(test.wantComponents, "\n"))
+			}
+			attributedString, err := DecodeAttributedString(encoded)
+			if err != nil {
+				t.Fatalf("DecodeAttributedString() error = %v", err)
+			}
+			if !reflect.DeepEqual(attributedString, test.wantString) {
+				t.Errorf("DecodeAttributedString() = %+v, want %+v", attributedString, test.wantString)
+			}
+		})
+	}
+}
+
+// typedStreamHeader is the header every attributedBody starts with.
+const typedStreamHeader = "040b73747265616d747970656481e803"
+
+func TestDecodeTypedStreamComponentsErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		encoded string
+		wantErr error
+	}{
+		{"empty", "", ErrTypedStreamTruncated},
+		{"unknown version", "050b73747265616d747970656481e803", ErrTypedStreamInvalidHeader},
+		{"wrong signature", "040b73747265616d747970657381e803", ErrTypedStreamInvalidHeader},
+		{"truncated class name", typedStreamHeader + "840140848484124e534174", ErrTypedStreamTruncated},
+		{"truncated string", helloAttributedBody[:len(typedStreamHeader)+150], ErrTypedStreamTruncated},
+		{"type reference past the table", typedStreamHeader + "a086", ErrTypedStreamBadReference},
+		{"object reference past the table", typedStreamHeader + "840140a086", ErrTypedStreamBadReference},
+		{"tag below the reference tag", typedStreamHeader + "1086", ErrTypedStreamUnknownTag},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := DecodeTypedStreamComponents(mustDecodeHex(t, test.encoded))
+			if !errors.Is(err, test.wantErr) {
+				t.Errorf("DecodeTypedStreamComponents() error = %v, want %v", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func FuzzDecodeTypedStreamComponents(f *testing.F) {
+	for _, fixture := range []string{helloAttributedBody, linkAttributedBody, inlineMediaAttributedBody} {
+		encoded, err := hex.DecodeString(fixture)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(encoded)
+	}
+	for _, attributedString := range []*AttributedString{
+		{String: "Hello"},
+		{String: "Bold 👋🏽", Runs: []AttributeRun{{Length: 4, Attributes: AttributeDictionary{
+			{Key: string(TextBoldAttributeName), Value: 1},
+			{Key: string(MessagePartAttributeName), Value: 0},
+		}}}},
+		{String: "￼", Runs: []AttributeRun{{Length: 1, Attributes: AttributeDictionary{
+			{Key: string(FileTransferGUIDAttributeName), Value: "at_0_GUID"},
+			{Key: string(InlineMediaWidthAttributeName), Value: 640.0},
+			{Key: string(InlineMediaHeightAttributeName), Value: int32(480)},
+		}}}},
+		{String: "see example.com", Runs: []AttributeRun{{Location: 4, Length: 11, Attributes: AttributeDictionary{
+			{Key: string(LinkAttributeName), Value: NSURL("https://example.com")},
+			{Key: string(MentionConfirmedMention), Value: AttributeDictionary{{Key: "nested", Value: true}}},
+		}}}},
+	} {
+		encoded, err := EncodeAttributedString(attributedString)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(encoded)
+	}
+	f.Fuzz(func(t *testing.T, encoded []byte) {
+		_, err := DecodeTypedStreamComponents(encoded)
+		if err != nil && !errors.Is(err, ErrTypedStreamInvalidHeader) && !errors.Is(err, ErrTypedStreamTruncated) &&
+			!errors.Is(err, ErrTypedStreamBadReference) && !errors.Is(err, ErrTypedStreamUnknownTag) {
+			t.Errorf("DecodeTypedStreamComponents() error = %v, want one of the ErrTypedStream errors", err)
+		}
+		// The model built on top of the components mustn't panic either
+		_, _ = DecodeAttributedString(encoded)
+	})
+}
@@ -0,0 +1,353 @@
+package macos
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+)
+
+var (
+	ErrUnsupportedArchiveValue = errors.New("value can't be archived in a typedstream")
+	ErrTooManyReferences       = errors.New("too many distinct strings or objects for single byte references")
+	ErrInvalidAttributeRun     = errors.New("attribute run is out of order or out of range")
+)
+
+type typedStreamClass struct {
+	name    string
+	version uint64
+}
+
+// Class hierarchies of the objects we archive, excluding NSObject at the root
+var (
+	nsAttributedStringClass = []typedStreamClass{{"NSAttributedString", 0}}
+	nsStringClass           = []typedStreamClass{{"NSString", 1}}
+	nsDictionaryClass       = []typedStreamClass{{"NSDictionary", 0}}
+	nsNumberClass           = []typedStreamClass{{"NSNumber", 0}, {"NSValue", 0}}
+	nsURLClass              = []typedStreamClass{{"NSURL", 0}}
+	nsObjectClass           = typedStreamClass{"NSObject", 0}
+)
+
+// EncodeAttributedString archives the attributed string as "streamtyped" v4 bytes, as found in attributedBody.
+// Gaps between runs are filled with runs without attributes, and runs with equal attributes share a dictionary.
+func EncodeAttributedString(attributedString *AttributedString) ([]byte, error) {
+	encoder := newTypedStreamEncoder()
+	encoder.writeHeader()
+	if err := encoder.writeObject(attributedString); err != nil {
+		return nil, err
+	}
+	return encoder.buffer.Bytes(), nil
+}
+
+// typedStreamEncoder writes the format read by typedStreamDecoder. Type strings and class names share one table,
+// and objects, classes and embedded type strings share another, both referenced by index from REFERENCE_TAG.
+type typedStreamEncoder struct {
+	buffer bytes.Buffer
+
+	sharedStrings map[string]int
+	objectCount   int
+	classes       map[string]int
+	embeddedTypes map[string]struct{}
+}
+
+func newTypedStreamEncoder() *typedStreamEncoder {
+	return &typedStreamEncoder{
+		sharedStrings: make(map[string]int),
+		classes:       make(map[string]int),
+		embeddedTypes: make(map[string]struct{}),
+	}
+}
+
+func (t *typedStreamEncoder) writeHeader() {
+	t.buffer.WriteByte(4)
+	_ = t.writeString("streamtyped")
+	_ = t.writeSignedInt(1000)
+}
+
+func (t *typedStreamEncoder) writeReference(index int) error {
+	if index > math.MaxUint8-REFERENCE_TAG {
+		return fmt.Errorf("%w: index %d", ErrTooManyReferences, index)
+	}
+	t.buffer.WriteByte(byte(REFERENCE_TAG + index))
+	return nil
+}
+
+func (t *typedStreamEncoder) writeSharedString(value string) error {
+	if index, ok := t.sharedStrings[value]; ok {
+		return t.writeReference(index)
+	}
+	t.sharedStrings[value] = len(t.sharedStrings)
+	t.buffer.WriteByte(START)
+	return t.writeString(value)
+}
+
+// writeTypes writes the type encoding of the values that follow, e.g. "@" for an object or "iI" for an int and an unsigned int.
+func (t *typedStreamEncoder) writeTypes(types string) error {
+	return t.writeSharedString(types)
+}
+
+func (t *typedStreamEncoder) writeString(value string) error {
+	if err := t.writeUnsignedInt(uint64(len(value))); err != nil {
+		return err
+	}
+	t.buffer.WriteString(value)
+	return nil
+}
+
+func (t *typedStreamEncoder) writeUnsignedInt(value uint64) error {
+	switch {
+	case value < 0x80:
+		t.buffer.WriteByte(byte(value))
+	case value <= math.MaxUint16:
+		t.buffer.WriteByte(I_16)
+		t.buffer.Write(binary.LittleEndian.AppendUint16(nil, uint16(value)))
+	case value <= math.MaxUint32:
+		t.buffer.WriteByte(I_32)
+		t.buffer.Write(binary.LittleEndian.AppendUint32(nil, uint32(value)))
+	default:
+		return fmt.Errorf("%w: unsigned int %d is too large", ErrUnsupportedArchiveValue, value)
+	}
+	return nil
+}
+
+// writeSignedInt only uses single bytes for positive values, as negative ones would collide with the tags.
+func (t *typedStreamEncoder) writeSignedInt(value int64) error {
+	switch {
+	case value >= 0 && value < 0x80:
+		t.buffer.WriteByte(byte(value))
+	case value >= math.MinInt16 && value <= math.MaxInt16:
+		t.buffer.WriteByte(I_16)
+		t.buffer.Write(binary.LittleEndian.AppendUint16(nil, uint16(int16(value))))
+	case value >= math.MinInt32 && value <= math.MaxInt32:
+		t.buffer.WriteByte(I_32)
+		t.buffer.Write(binary.LittleEndian.AppendUint32(nil, uint32(int32(value))))
+	default:
+		return fmt.Errorf("%w: signed int %d is too large", ErrUnsupportedArchiveValue, value)
+	}
+	return nil
+}
+
+func (t *typedStreamEncoder) writeDouble(value float64) {
+	t.buffer.WriteByte(DECIMAL)
+	t.buffer.Write(binary.LittleEndian.AppendUint64(nil, math.Float64bits(value)))
+}
+
+// writeClass writes a class hierarchy, or a reference to it if it was already written.
+func (t *typedStreamEncoder) writeClass(hierarchy []typedStreamClass) error {
+	if len(hierarchy) == 0 {
+		hierarchy = []typedStreamClass{nsObjectClass}
+	}
+	class := hierarchy[0]
+	if index, ok := t.classes[class.name]; ok {
+		return t.writeReference(index)
+	}
+	t.buffer.WriteByte(START)
+	if err := t.writeSharedString(class.name); err != nil {
+		return err
+	}
+	if err := t.writeUnsignedInt(class.version); err != nil {
+		return err
+	}
+	t.classes[class.name] = t.objectCount
+	t.objectCount += 1
+	if class == nsObjectClass {
+		t.buffer.WriteByte(EMPTY)
+		return nil
+	}
+	return t.writeClass(hierarchy[1:])
+}
+
+// startObject writes the type and class of a new object, whose fields follow until the END byte.
+func (t *typedStreamEncoder) startObject(hierarchy []typedStreamClass) error {
+	if err := t.writeTypes("@"); err != nil {
+		return err
+	}
+	t.buffer.WriteByte(START)
+	t.objectCount += 1
+	return t.writeClass(hierarchy)
+}
+
+func (t *typedStreamEncoder) writeObject(value any) error {
+	switch typedValue := value.(type) {
+	case *AttributedString:
+		return t.writeAttributedString(typedValue)
+	case string:
+		return t.writeNSString(typedValue)
+	case NSURL:
+		return t.writeNSURL(typedValue)
+	case AttributeDictionary:
+		return t.writeNSDictionary(typedValue)
+	case bool:
+		var number int64
+		if typedValue {
+			number = 1
+		}
+		return t.writeNSNumber("c", number, 0)
+	case int:
+		return t.writeNSNumber("q", int64(typedValue), 0)
+	case int32:
+		return t.writeNSNumber("i", int64(typedValue), 0)
+	case int64:
+		return t.writeNSNumber("q", typedValue, 0)
+	case float64:
+		return t.writeNSNumber("d", 0, typedValue)
+	default:
+		return fmt.Errorf("%w: %T", ErrUnsupportedArchiveValue, value)
+	}
+}
+
+func (t *typedStreamEncoder) writeNSString(value string) error {
+	if err := t.startObject(nsStringClass); err != nil {
+		return err
+	}
+	if err := t.writeTypes("+"); err != nil {
+		return err
+	}
+	if err := t.writeString(value); err != nil {
+		return err
+	}
+	t.buffer.WriteByte(END)
+	return nil
+}
+
+// writeNSURL writes a URL without a base URL, followed by its string.
+func (t *typedStreamEncoder) writeNSURL(value NSURL) error {
+	if err := t.startObject(nsURLClass); err != nil {
+		return err
+	}
+	if err := t.writeTypes("c"); err != nil {
+		return err
+	}
+	_ = t.writeSignedInt(0)
+	if err := t.writeNSString(string(value)); err != nil {
+		return err
+	}
+	t.buffer.WriteByte(END)
+	return nil
+}
+
+// writeNSNumber writes the number's type encoding as an embedded C string, followed by the value in that type.
+// The type is written both as the C string and as the type of the value, so a type that was seen before is repeated.
+func (t *typedStreamEncoder) writeNSNumber(numberType string, intValue int64, floatValue float64) error {
+	if err := t.startObject(nsNumberClass); err != nil {
+		return err
+	}
+	if err := t.writeTypes("*"); err != nil {
+		return err
+	}
+	t.buffer.WriteByte(START)
+	if _, ok := t.embeddedTypes[numberType]; !ok {
+		t.embeddedTypes[numberType] = struct{}{}
+		t.objectCount += 1
+	}
+	if err := t.writeSharedString(numberType); err != nil {
+		return err
+	}
+	if err := t.writeTypes(numberType); err != nil {
+		return err
+	}
+	if numberType == "d" {
+		t.writeDouble(floatValue)
+	} else if err := t.writeSignedInt(intValue); err != nil {
+		return err
+	}
+	t.buffer.WriteByte(END)
+	return nil
+}
+
+func (t *typedStreamEncoder) writeNSDictionary(dictionary AttributeDictionary) error {
+	if err := t.startObject(nsDictionaryClass); err != nil {
+		return err
+	}
+	if err := t.writeTypes("i"); err != nil {
+		return err
+	}
+	if err := t.writeSignedInt(int64(len(dictionary))); err != nil {
+		return err
+	}
+	for _, entry := range dictionary {
+		if err := t.writeNSString(entry.Key); err != nil {
+			return err
+		}
+		if err := t.writeObject(entry.Value); err != nil {
+			return fmt.Errorf("writing value of %s: %w", entry.Key, err)
+		}
+	}
+	t.buffer.WriteByte(END)
+	return nil
+}
+
+// writeAttributedString writes the string followed by each run as a 1-based attribute dictionary index and a length.
+// A dictionary is only written the first time its index is used.
+func (t *typedStreamEncoder) writeAttributedString(attributedString *AttributedString) error {
+	runs, err := fillAttributeRuns(attributedString)
+	if err != nil {
+		return err
+	}
+	if err := t.startObject(nsAttributedStringClass); err != nil {
+		return err
+	}
+	if err := t.writeNSString(attributedString.String); err != nil {
+		return err
+	}
+	var dictionaries []AttributeDictionary
+	for _, run := range runs {
+		dictionaryIndex := indexOfAttributeDictionary(dictionaries, run.Attributes)
+		isNew := dictionaryIndex < 0
+		if isNew {
+			dictionaries = append(dictionaries, run.Attributes)
+			dictionaryIndex = len(dictionaries) - 1
+		}
+		if err := t.writeTypes("iI"); err != nil {
+			return err
+		}
+		if err := t.writeSignedInt(int64(dictionaryIndex + 1)); err != nil {
+			return err
+		}
+		if err := t.writeUnsignedInt(uint64(run.Length)); err != nil {
+			return err
+		}
+		if isNew {
+			if err := t.writeNSDictionary(run.Attributes); err != nil {
+				return err
+			}
+		}
+	}
+	t.buffer.WriteByte(END)
+	return nil
+}
+
+func indexOfAttributeDictionary(dictionaries []AttributeDictionary, dictionary AttributeDictionary) int {
+	for index, existing := range dictionaries {
+		if len(existing) == 0 && len(dictionary) == 0 || reflect.DeepEqual(existing, dictionary) {
+			return index
+		}
+	}
+	return -1
+}
+
+// fillAttributeRuns returns runs that cover the whole string without gaps, as NSAttributedString archives them.
+func fillAttributeRuns(attributedString *AttributedString) ([]AttributeRun, error) {
+	length := utf16Length(attributedString.String)
+	runs := make([]AttributeRun, 0, len(attributedString.Runs)+1)
+	position := 0
+	for _, run := range attributedString.Runs {
+		if run.Location < position || run.Length < 0 || run.Location+run.Length > length {
+			return nil, fmt.Errorf("%w: [%d, %d) in string of length %d", ErrInvalidAttributeRun, run.Location, run.Location+run.Length, length)
+		}
+		if run.Length == 0 {
+			continue
+		}
+		if run.Location > position {
+			runs = append(runs, AttributeRun{Location: position, Length: run.Location - position})
+		}
+		runs = append(runs, run)
+		position = run.Location + run.Length
+	}
+	if position < length {
+		runs = append(runs, AttributeRun{Location: position, Length: length - position})
+	}
+	return runs, nil
+}
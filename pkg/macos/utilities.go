@@ -4,11 +4,10 @@ import (
 	"bytes"
 	"encoding/base64"
 	"fmt"
+	"html"
 	"io"
 	"math"
-	"os"
 	"os/exec"
-	"path/filepath"
 	"strings"
 	"time"
 
@@ -17,6 +16,7 @@ import (
 	"maunium.net/go/mautrix/bridgev2"
 	"maunium.net/go/mautrix/bridgev2/networkid"
 	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
 )
 
 const FITNESS_RECEIVER = "$(kIMTranscriptPluginBreadcrumbTextReceiverIdentifier)"
@@ -25,8 +25,20 @@ var AppleEpoch = time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC)
 var AppleEpochUnix = AppleEpoch.Unix()
 var AppleEpochUnixNano = AppleEpoch.UnixNano()
 
+const messagesPortalIDPrefix = "MessagesID"
+
 func MakeMessagesPortalID(userLoginID networkid.UserLoginID, chatGUID string) networkid.PortalID {
-	return networkid.PortalID(fmt.Sprintf("%s:%s:%s", "MessagesID", userLoginID, chatGUID))
+	return networkid.PortalID(fmt.Sprintf("%s:%s:%s", messagesPortalIDPrefix, userLoginID, chatGUID))
+}
+
+func ParseMessagesPortalID(portalID networkid.PortalID) (networkid.UserLoginID, string, error) {
+	// Chat GUIDs look like "iMessage;-;+15555550123" and user login IDs are phone numbers,
+	// so only the first two colons are separators
+	parts := strings.SplitN(string(portalID), ":", 3)
+	if len(parts) != 3 || parts[0] != messagesPortalIDPrefix || parts[2] == "" {
+		return "", "", fmt.Errorf("invalid messages portal ID: %s", portalID)
+	}
+	return networkid.UserLoginID(parts[1]), parts[2], nil
 }
 
 func RunOsascript(script string, args ...string) (string, string, error) {
@@ -126,17 +138,6 @@ func ParseFormatPhoneNumber(phoneNumber string, countryCode string) (*networkid.
 	}
 }
 
-func ReplaceHomeDirectory(input string) (string, error) {
-	if strings.HasPrefix(input, "~/") {
-		home, err := os.UserHomeDir()
-		if err != nil {
-			return "", fmt.Errorf("failed to get home directory: %w", err)
-		}
-		return filepath.Join(home, input[2:]), nil
-	}
-	return input, nil
-}
-
 // https://github.com/tinkerator/xxd/blob/main/xxd.go
 func Dump(data []byte) (lines []string) {
 	offset := 0 & 15
@@ -258,19 +259,28 @@ func ConvertConvertedMessageToString(c *bridgev2.ConvertedMessage) string {
 }
 
 func ConvertEditToString(c *bridgev2.ConvertedEdit) string {
-	result := fmt.Sprintf("ConvertedEdit:\nDeleted: %d\nModified: %d\nNew: %d", len(c.DeletedParts), len(c.ModifiedParts), len(c.AddedParts.Parts))
+	addedParts := []*bridgev2.ConvertedMessagePart{}
+	if c.AddedParts != nil {
+		addedParts = c.AddedParts.Parts
+	}
+	result := fmt.Sprintf("ConvertedEdit:\nDeleted: %d\nModified: %d\nNew: %d", len(c.DeletedParts), len(c.ModifiedParts), len(addedParts))
 	for _, d := range c.DeletedParts {
 		result += fmt.Sprintf("\n\tD - %s", d.ID)
 	}
 	for _, m := range c.ModifiedParts {
 		result += fmt.Sprintf("\n\tM - %s", m.Type)
 	}
-	for _, a := range c.AddedParts.Parts {
+	for _, a := range addedParts {
 		result += fmt.Sprintf("\n\tA - %s", a.Type)
 	}
 	return result
 }
 
-func GetMentionText(username string, server string, name string) string {
-	return fmt.Sprintf("<a href=\"https://matrix.to/#/@%s:%s\">@%s</a>", username, server, name)
+// GetMentionText returns a pill for the Matrix user, or just the name if the user ID isn't valid.
+func GetMentionText(userID id.UserID, name string) string {
+	uri := userID.URI()
+	if uri == nil {
+		return html.EscapeString(name)
+	}
+	return fmt.Sprintf("<a href=\"%s\">%s</a>", html.EscapeString(uri.MatrixToURL()), html.EscapeString(name))
 }
@@ -59,17 +59,16 @@ func checkError(err error) {
 func test_get_chat_details() {
 	logger, err := prepareLog([]byte(logConfig))
 	checkError(err)
-	messagesClient, err := macos.GetMessagesClient("foobar", logger)
+	messagesClient, err := macos.GetMessagesClient("foobar", logger, macos.DataPaths{}, macos.OsascriptRunner{})
 	checkError(err)
-	contactsClient, err := macos.GetContactsClient("foobar")
+	contactsClient, err := macos.GetContactsClient("foobar", macos.DataPaths{}, "US", macos.OsascriptRunner{})
 	checkError(err)
 	chatMap, err := messagesClient.GetAllChatIDsNames()
 	checkError(err)
 	contactsMap, err := contactsClient.GetContactsMap()
 	checkError(err)
-	for ID := range chatMap {
-		chatID := networkid.PortalID(ID)
-		println(ID)
+	for chatID := range chatMap {
+		println(chatID)
 		chatName, avatar, err := messagesClient.GetChatDetails(chatID)
 		checkError(err)
 		println("\tName: " + *chatName)
@@ -109,7 +108,7 @@ func test_get_chat_details() {
 func test_typedstream() {
 	logger, err := prepareLog([]byte(logConfig))
 	checkError(err)
-	messagesClient, err := macos.GetMessagesClient("foobar", logger)
+	messagesClient, err := macos.GetMessagesClient("foobar", logger, macos.DataPaths{}, macos.OsascriptRunner{})
 	checkError(err)
 	messages, err := messagesClient.GetMessagesBetween(33492, 33494)
 	checkError(err)
@@ -138,7 +137,7 @@ func test_parse_attributed_body() {
 func test_parse_all_messages() {
 	logger, err := prepareLog([]byte(logConfig))
 	checkError(err)
-	messagesClient, err := macos.GetMessagesClient("foobar", logger)
+	messagesClient, err := macos.GetMessagesClient("foobar", logger, macos.DataPaths{}, macos.OsascriptRunner{})
 	checkError(err)
 	// messages, err := messagesClient.GetMessagesBetween(33490, 33499)
 	messages, err := messagesClient.GetMessagesNewerThan(0)
@@ -155,8 +154,9 @@ func test_parse_all_messages() {
 		DryRun: true,
 	}
 
+	// Everything is read from the start, so every message is new
 	for _, message := range messages {
-		mc.HandleiMessage(message)
+		mc.HandleiMessage(message, true)
 	}
 }
 